@@ -0,0 +1,81 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionTypeActive is the condition type used to report if the freeze is stopping the idlers.
+	ConditionTypeActive = "Active"
+)
+
+// IdlingFreezeSpec defines when the idlers are stopped from idling namespaces, and which namespaces.
+type IdlingFreezeSpec struct {
+	// Start is when the freeze starts, if empty it starts straight away.
+	// +optional
+	Start *metav1.Time `json:"start,omitempty"`
+	// End is when the freeze ends, if empty it lasts until the freeze is removed.
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
+	// Reason is logged by the idlers when they skip a namespace because of the freeze.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// NamespaceSelector selects the namespaces that are frozen, if empty all namespaces are frozen.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// IdlingFreezeStatus defines the observed state of IdlingFreeze
+type IdlingFreezeStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Start",type="date",JSONPath=".spec.start"
+// +kubebuilder:printcolumn:name="End",type="date",JSONPath=".spec.end"
+// +kubebuilder:printcolumn:name="Active",type="string",JSONPath=".status.conditions[?(@.type==\"Active\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".spec.reason"
+
+// IdlingFreeze is the Schema for the idlingfreezes API
+type IdlingFreeze struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdlingFreezeSpec   `json:"spec,omitempty"`
+	Status IdlingFreezeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IdlingFreezeList contains a list of IdlingFreeze
+type IdlingFreezeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdlingFreeze `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdlingFreeze{}, &IdlingFreezeList{})
+}
@@ -0,0 +1,154 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionTypeApplied is the condition type used to report if the policy is in use by the idler.
+	ConditionTypeApplied = "Applied"
+)
+
+// Selector is a label selector requirement used to find resources to idle.
+type Selector struct {
+	// Name is the label key the selector applies to.
+	Name string `json:"name"`
+	// Operator is the label selector operator, one of `in`, `notin`, `=`, `==`, `!=`, `exists`, `!`, `gt` or `lt`.
+	Operator string `json:"operator"`
+	// Values is the list of values used by the operator.
+	// +optional
+	Values []string `json:"values,omitempty"`
+}
+
+// HitQuery is a named go template that renders a promql query returning the number of hits to a namespace.
+type HitQuery struct {
+	// Name is used to identify the query in the logs.
+	Name string `json:"name"`
+	// Query is the promql query template, the variables `.Namespace`, `.Project`, `.Environment`, `.Interval` and
+	// `.StatusRegex` are available.
+	Query string `json:"query"`
+}
+
+// NamespaceSelectorsLabels defines the namespace labels used to identify lagoon environments.
+type NamespaceSelectorsLabels struct {
+	ProjectName       string `json:"projectName,omitempty"`
+	EnvironmentName   string `json:"environmentName,omitempty"`
+	ProjectIdling     string `json:"projectIdling,omitempty"`
+	EnvironmentIdling string `json:"environmentIdling,omitempty"`
+	EnvironmentType   string `json:"environmentType,omitempty"`
+}
+
+// CLIPolicy defines the selectors and checks used by the cli idler.
+type CLIPolicy struct {
+	SkipBuildCheck   bool `json:"skipBuildCheck,omitempty"`
+	SkipCronCheck    bool `json:"skipCronCheck,omitempty"`
+	SkipProcessCheck bool `json:"skipProcessCheck,omitempty"`
+	// +optional
+	Namespace []Selector `json:"namespace,omitempty"`
+	// +optional
+	Builds []Selector `json:"builds,omitempty"`
+	// +optional
+	Deployments []Selector `json:"deployments,omitempty"`
+	// +optional
+	Pods []Selector `json:"pods,omitempty"`
+}
+
+// ServicePolicy defines the selectors, checks and intervals used by the service idler.
+type ServicePolicy struct {
+	SkipBuildCheck   bool `json:"skipBuildCheck,omitempty"`
+	SkipHitCheck     bool `json:"skipHitCheck,omitempty"`
+	SkipIngressPatch bool `json:"skipIngressPatch,omitempty"`
+	// PodCheckInterval overrides the global pod check interval.
+	// +optional
+	PodCheckInterval *metav1.Duration `json:"podCheckInterval,omitempty"`
+	// PrometheusCheckInterval overrides the global prometheus check interval.
+	// +optional
+	PrometheusCheckInterval *metav1.Duration `json:"prometheusCheckInterval,omitempty"`
+	// +optional
+	Namespace []Selector `json:"namespace,omitempty"`
+	// +optional
+	Builds []Selector `json:"builds,omitempty"`
+	// +optional
+	Deployments []Selector `json:"deployments,omitempty"`
+	// +optional
+	Pods []Selector `json:"pods,omitempty"`
+	// +optional
+	Ingress []Selector `json:"ingress,omitempty"`
+	// StatefulSets selects the statefulsets to idle, if empty no statefulsets are idled.
+	// +optional
+	StatefulSets []Selector `json:"statefulSets,omitempty"`
+	// CronJobs selects the cronjobs to suspend, if empty no cronjobs are suspended.
+	// +optional
+	CronJobs []Selector `json:"cronJobs,omitempty"`
+	// HTTPRoutes selects the Gateway API HTTPRoutes to redirect to the backend, if empty no httproutes are patched.
+	// +optional
+	HTTPRoutes []Selector `json:"httpRoutes,omitempty"`
+	// HitQueries replaces the default prometheus queries used to count the hits to a namespace.
+	// +optional
+	HitQueries []HitQuery `json:"hitQueries,omitempty"`
+	// HitStatusRegex is the regex of the status codes counted as hits, defaults to `2[0-9x]{2}`.
+	// +optional
+	HitStatusRegex string `json:"hitStatusRegex,omitempty"`
+}
+
+// IdlingPolicySpec defines the desired idling behaviour, it mirrors the selectors file.
+type IdlingPolicySpec struct {
+	NamespaceSelectorsLabels NamespaceSelectorsLabels `json:"namespaceSelectorsLabels,omitempty"`
+	ServiceName              string                   `json:"serviceName,omitempty"`
+	CLI                      CLIPolicy                `json:"cli,omitempty"`
+	Service                  ServicePolicy            `json:"service,omitempty"`
+}
+
+// IdlingPolicyStatus defines the observed state of IdlingPolicy
+type IdlingPolicyStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Applied",type="string",JSONPath=".status.conditions[?(@.type==\"Applied\")].status"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Applied\")].reason"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// IdlingPolicy is the Schema for the idlingpolicies API
+type IdlingPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdlingPolicySpec   `json:"spec,omitempty"`
+	Status IdlingPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// IdlingPolicyList contains a list of IdlingPolicy
+type IdlingPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdlingPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdlingPolicy{}, &IdlingPolicyList{})
+}
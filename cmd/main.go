@@ -16,27 +16,35 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	prometheusapi "github.com/prometheus/client_golang/api"
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	"github.com/uselagoon/aergia-controller/internal/cli"
 	"github.com/uselagoon/aergia-controller/internal/controllers"
+	"github.com/uselagoon/aergia-controller/internal/handlers/admin"
+	"github.com/uselagoon/aergia-controller/internal/handlers/filewatcher"
 	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	"github.com/uselagoon/aergia-controller/internal/handlers/notifier"
+	"github.com/uselagoon/aergia-controller/internal/handlers/scheduler"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
 	"github.com/uselagoon/aergia-controller/internal/handlers/unidler"
 	variables "github.com/uselagoon/machinery/utils/variables"
-	"gopkg.in/robfig/cron.v2"
-	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -47,9 +55,16 @@ var (
 
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
+	_ = idlingv1alpha1.AddToScheme(scheme)
+	_ = gatewayv1.AddToScheme(scheme)
 }
 
 func main() {
+	// the subcommands run against a cluster from the command line, without starting the controller
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(ctrl.SetupSignalHandler(), os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	var metricsAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
@@ -62,8 +77,9 @@ func main() {
 	var dryRun bool
 	var selectorsFile string
 	var skipHitCheck bool
-	var cliCron string     // interval for the cli idler.
-	var serviceCron string // interval for the service idler.
+	var cliCron string      // interval for the cli idler.
+	var serviceCron string  // interval for the service idler.
+	var scheduleCron string // interval for the schedule idler.
 
 	var prometheusAddress string
 	var prometheusCheckInterval string
@@ -76,6 +92,34 @@ func main() {
 
 	var defaultHTTPResponseCode int
 
+	var enableIdlingPolicy bool
+	var idlingPolicyName string
+	var enableIdlingFreeze bool
+
+	var httpRouteBackend string
+
+	var listsDir string
+	var watchConfig bool
+
+	var notifierConfig string
+
+	var adminAPIAddr string
+	var adminAPICertDir string
+
+	var idlerConcurrency int
+	var idlerNamespaceTimeout string
+	var minAwakeTime string
+	var planDir string
+
+	var unidleNamespaceRate int
+	var unidleNamespaceBurst int
+	var unidleClientRate int
+	var unidleClientBurst int
+
+	var tracingEndpoint string
+	var tracingInsecure bool
+	var tracingSamplePercent int
+
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.BoolVar(&secureMetrics, "metrics-secure", true,
@@ -95,6 +139,8 @@ func main() {
 		"The cron definition for how often to run the cli idling process.")
 	flag.StringVar(&serviceCron, "service-idler-cron", "0 */4 * * *",
 		"The cron definition for how often to run the service idling process.")
+	flag.StringVar(&scheduleCron, "schedule-idler-cron", "*/5 * * * *",
+		"The cron definition for how often to check namespaces that have an idling schedule.")
 	flag.StringVar(&prometheusAddress, "prometheus-endpoint", "http://monitoring-kube-prometheus-prometheus.monitoring.svc:9090",
 		"The address for the prometheus endpoint to check against")
 	flag.StringVar(&prometheusCheckInterval, "prometheus-interval", "4h",
@@ -111,18 +157,79 @@ func main() {
 		"The secret to use for verifying unidling requests.")
 	flag.IntVar(&unidlerHTTPPort, "unidler-port", 5000, "Port for the unidler service to listen on.")
 	flag.IntVar(&defaultHTTPResponseCode, "default-http-response-code", 404, "Default HTTP response code.")
+	flag.BoolVar(&enableIdlingPolicy, "enable-idling-policy", false,
+		"Flag to enable watching IdlingPolicy resources, the selected policy will replace the selectors file while it exists.")
+	flag.StringVar(&idlingPolicyName, "idling-policy-name", "default",
+		"The name of the IdlingPolicy resource to use for idling selectors.")
+	flag.BoolVar(&enableIdlingFreeze, "enable-idling-freeze", false,
+		"Flag to enable watching IdlingFreeze resources, the service and cli idlers won't idle namespaces selected by an active freeze.")
+	flag.StringVar(&httpRouteBackend, "httproute-backend", "",
+		"The aergia service that idled HTTPRoutes are redirected to, in the format namespace/name:port. If empty, HTTPRoutes are not idled.")
+	flag.StringVar(&listsDir, "lists-path", "/lists",
+		"The path to the directory containing the allow and block lists.")
+	flag.BoolVar(&watchConfig, "watch-config", true,
+		"Flag to enable reloading the selectors file and the allow and block lists when they change.")
+	flag.StringVar(&notifierConfig, "notifier-config", "",
+		"The path to the file containing the webhook endpoints to notify when environments are idled or unidled. If empty, no notifications are sent.")
+	flag.StringVar(&adminAPIAddr, "admin-api-bind-address", "0",
+		"The address the admin api binds to, for example :8444. Leave as 0 to disable the admin api.")
+	flag.StringVar(&adminAPICertDir, "admin-api-cert-dir", "",
+		"The directory containing the tls.crt and tls.key for the admin api. If empty, a self-signed certificate is used.")
+	flag.IntVar(&idlerConcurrency, "idler-concurrency", 1,
+		"The number of namespaces each idler checks at the same time.")
+	flag.StringVar(&minAwakeTime, "min-awake-time", "0",
+		"The minimum time an environment stays awake after it is unidled before it can be idled again, 0 to disable it.")
+	flag.StringVar(&idlerNamespaceTimeout, "idler-namespace-timeout", "5m",
+		"The maximum time an idler can spend checking a single namespace, 0 for no timeout.")
+	flag.StringVar(&planDir, "plan-dir", "",
+		"The directory to write the plan of each idler run to, as json and as a table. If empty, plans are only served by the admin api.")
+	flag.IntVar(&unidleNamespaceRate, "unidle-namespace-rate", 0,
+		"The number of requests a minute that can unidle each namespace, 0 for no limit.")
+	flag.IntVar(&unidleNamespaceBurst, "unidle-namespace-burst", 5,
+		"The number of requests that can unidle a namespace in a burst, before the namespace rate applies.")
+	flag.IntVar(&unidleClientRate, "unidle-client-rate", 0,
+		"The number of requests a minute that each client ip can make to unidle namespaces, 0 for no limit.")
+	flag.IntVar(&unidleClientBurst, "unidle-client-burst", 10,
+		"The number of requests to unidle namespaces a client ip can make in a burst, before the client rate applies.")
+	flag.StringVar(&tracingEndpoint, "tracing-endpoint", "",
+		"The host:port of an OTLP gRPC collector to export traces to, for example localhost:4317. If empty, no traces are exported.")
+	flag.BoolVar(&tracingInsecure, "tracing-insecure", true,
+		"Flag to export traces to the collector without TLS, for a collector running alongside aergia.")
+	flag.IntVar(&tracingSamplePercent, "tracing-sample-percent", 100,
+		"The percentage of new traces that are exported, traces continued from a sampled request are always exported.")
 	flag.Parse()
 
 	selectorsFile = variables.GetEnv("SELECTORS_YAML_FILE", selectorsFile)
 	verifiedUnidling = variables.GetEnvBool("VERIFIED_UNIDLING", verifiedUnidling)
 	verifiedSecret = variables.GetEnv("VERIFY_SECRET", verifiedSecret)
 	defaultHTTPResponseCode = variables.GetEnvInt("DEFAULT_HTTP_RESPONSE_CODE", defaultHTTPResponseCode)
+	enableIdlingPolicy = variables.GetEnvBool("ENABLE_IDLING_POLICY", enableIdlingPolicy)
+	idlingPolicyName = variables.GetEnv("IDLING_POLICY_NAME", idlingPolicyName)
+	enableIdlingFreeze = variables.GetEnvBool("ENABLE_IDLING_FREEZE", enableIdlingFreeze)
+	httpRouteBackend = variables.GetEnv("HTTPROUTE_BACKEND", httpRouteBackend)
+	listsDir = variables.GetEnv("LISTS_PATH", listsDir)
+	watchConfig = variables.GetEnvBool("WATCH_CONFIG", watchConfig)
+	notifierConfig = variables.GetEnv("NOTIFIER_CONFIG_FILE", notifierConfig)
+	adminAPIAddr = variables.GetEnv("ADMIN_API_BIND_ADDRESS", adminAPIAddr)
+	adminAPICertDir = variables.GetEnv("ADMIN_API_CERT_DIR", adminAPICertDir)
+	idlerConcurrency = variables.GetEnvInt("IDLER_CONCURRENCY", idlerConcurrency)
+	idlerNamespaceTimeout = variables.GetEnv("IDLER_NAMESPACE_TIMEOUT", idlerNamespaceTimeout)
+	minAwakeTime = variables.GetEnv("MIN_AWAKE_TIME", minAwakeTime)
+	planDir = variables.GetEnv("PLAN_DIR", planDir)
+	unidleNamespaceRate = variables.GetEnvInt("UNIDLE_NAMESPACE_RATE", unidleNamespaceRate)
+	unidleNamespaceBurst = variables.GetEnvInt("UNIDLE_NAMESPACE_BURST", unidleNamespaceBurst)
+	unidleClientRate = variables.GetEnvInt("UNIDLE_CLIENT_RATE", unidleClientRate)
+	unidleClientBurst = variables.GetEnvInt("UNIDLE_CLIENT_BURST", unidleClientBurst)
+	tracingEndpoint = variables.GetEnv("TRACING_ENDPOINT", tracingEndpoint)
+	tracingInsecure = variables.GetEnvBool("TRACING_INSECURE", tracingInsecure)
+	tracingSamplePercent = variables.GetEnvInt("TRACING_SAMPLE_PERCENT", tracingSamplePercent)
 
 	dryRun = variables.GetEnvBool("DRY_RUN", dryRun)
 
 	unidlerHTTPPort = variables.GetEnvInt("UNIDLER_PORT", unidlerHTTPPort)
 	cliCron = variables.GetEnv("CLI_CRON", cliCron)
 	serviceCron = variables.GetEnv("SERVICE_CRON", serviceCron)
+	scheduleCron = variables.GetEnv("SCHEDULE_CRON", scheduleCron)
 	enableServiceIdler = variables.GetEnvBool("ENABLE_SERVICE_IDLER", enableServiceIdler)
 	enableCLIIdler = variables.GetEnvBool("ENABLE_CLI_IDLER", enableCLIIdler)
 	podCheckInterval = variables.GetEnv("POD_CHECK_INTERVAL", podCheckInterval)
@@ -144,17 +251,67 @@ func main() {
 		setupLog.Error(err, "unable to decode prometheus check interval")
 		os.Exit(1)
 	}
+	timeIdlerNamespaceTimeout, err := time.ParseDuration(idlerNamespaceTimeout)
+	if err != nil {
+		setupLog.Error(err, "unable to decode idler namespace timeout")
+		os.Exit(1)
+	}
+	timeMinAwakeTime, err := time.ParseDuration(minAwakeTime)
+	if err != nil {
+		setupLog.Error(err, "unable to decode minimum awake time")
+		os.Exit(1)
+	}
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
 		o.Development = true
 	}))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    tracingEndpoint,
+		Insecure:    tracingInsecure,
+		SampleRatio: float64(tracingSamplePercent) / 100,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to setup tracing")
+		os.Exit(1)
+	}
+	if tracingEndpoint != "" {
+		setupLog.Info("exporting traces", "endpoint", tracingEndpoint)
+	}
+
 	// read the selector file into idlerdata struct.
+	// the idler package is shadowed by the idler below, keep the reader for reloading the selectors
+	readSelectors := idler.ReadSelectors
 	selectors, err := readSelectors(selectorsFile)
 	if err != nil {
 		setupLog.Error(err, "unable to decode selectors yaml")
 		os.Exit(1)
 	}
 
+	var routeBackend idler.HTTPRouteBackend
+	if httpRouteBackend != "" {
+		routeBackend, err = idler.ParseHTTPRouteBackend(httpRouteBackend)
+		if err != nil {
+			setupLog.Error(err, "unable to decode httproute backend")
+			os.Exit(1)
+		}
+	}
+
+	var notify *notifier.Notifier
+	if notifierConfig != "" {
+		notify, err = notifier.ReadNotifier(notifierConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to decode notifier config")
+			os.Exit(1)
+		}
+		notify.Log = ctrl.Log.WithName("aergia-controller").WithName("Notifier")
+		if notify.ProjectLabel == "" {
+			notify.ProjectLabel = selectors.NamespaceSelectorsLabels.ProjectName
+		}
+		if notify.EnvironmentLabel == "" {
+			notify.EnvironmentLabel = selectors.NamespaceSelectorsLabels.EnvironmentName
+		}
+	}
+
 	if skipHitCheck {
 		selectors.Service.SkipHitCheck = skipHitCheck
 	}
@@ -195,26 +352,27 @@ func main() {
 		os.Exit(1)
 	}
 
-	// if a blockedagents file is found, provide them to the unidler to block agents from unidling environments
-	// provides nil if no file found
-	allowedAgents, _ := unidler.ReadSliceFromFile("/lists/allowedagents")
-	blockedAgents, _ := unidler.ReadSliceFromFile("/lists/blockedagents")
-	allowedIPs, _ := unidler.ReadSliceFromFile("/lists/allowedips")
-	blockedIPs, _ := unidler.ReadSliceFromFile("/lists/blockedips")
+	// read the allow and block lists for the unidler, any missing files are treated as empty lists
+	accessLists, err := unidler.ReadAccessLists(listsDir)
+	if err != nil {
+		setupLog.Error(err, "invalid entries in the allow and block lists")
+	}
 	u := &unidler.Unidler{
 		Client:                  mgr.GetClient(),
 		Log:                     ctrl.Log.WithName("aergia-controller").WithName("Unidler"),
 		RefreshInterval:         refreshInterval,
 		Debug:                   debug,
-		AllowedUserAgents:       allowedAgents,
-		BlockedUserAgents:       blockedAgents,
-		AllowedIPs:              allowedIPs,
-		BlockedIPs:              blockedIPs,
 		UnidlerHTTPPort:         unidlerHTTPPort,
 		VerifiedUnidling:        verifiedUnidling,
 		VerifiedSecret:          verifiedSecret,
 		DefaultHTTPResponseCode: defaultHTTPResponseCode,
+		HTTPRoutes:              httpRouteBackend != "",
+		NamespaceLimiter:        unidler.NewLimiter(unidleNamespaceRate, unidleNamespaceBurst),
+		ClientLimiter:           unidler.NewLimiter(unidleClientRate, unidleClientBurst),
+		Recorder:                mgr.GetEventRecorder("aergia-controller"),
+		Notifier:                notify,
 	}
+	u.SetAccessLists(accessLists)
 
 	prometheusClient, err := prometheusapi.NewClient(prometheusapi.Config{
 		Address: prometheusAddress,
@@ -225,43 +383,112 @@ func main() {
 	}
 
 	// setup the idler with the k8s and lagoon clients
+	hitSource := &idler.PrometheusHitSource{Client: prometheusClient, KubeClient: mgr.GetClient()}
 	idler := &idler.Idler{
 		Client:                  mgr.GetClient(),
+		RestConfig:              mgr.GetConfig(),
 		Log:                     ctrl.Log.WithName("aergia-controller").WithName("ServiceIdler"),
 		PodCheckInterval:        timePodCheckInterval,
-		PrometheusClient:        prometheusClient,
+		HitSource:               hitSource,
 		PrometheusCheckInterval: timePrometheusCheckInterval,
+		HTTPRouteBackend:        routeBackend,
+		Recorder:                mgr.GetEventRecorder("aergia-controller"),
+		Notifier:                notify,
+		Concurrency:             idlerConcurrency,
+		NamespaceTimeout:        timeIdlerNamespaceTimeout,
+		MinAwake:                timeMinAwakeTime,
+		PlanDir:                 planDir,
 		DryRun:                  dryRun,
 		Debug:                   debug,
 		Selectors:               selectors,
 	}
+	// the hit queries can be changed by an idling policy, so always use the active selectors
+	hitSource.Selectors = idler.GetSelectors
 
 	// Set up the cron job intervals for the CLI and service idlers.
-	c := cron.New()
-
-	// add the cronjobs we need.
+	// the crons only run on the leader, so that multiple replicas don't idle the same environments
+	cronJobs := []scheduler.Job{}
 	// CLI Idler
 	if enableCLIIdler {
-		setupLog.Info("starting cli idler")
-		_, err := c.AddFunc(cliCron, func() {
-			idler.CLIIdler()
-		})
-		if err != nil {
-			setupLog.Error(err, "unable to create cli idler cronjob", "controller", "Idling")
-		}
+		cronJobs = append(cronJobs, scheduler.Job{Name: "cli idler", Schedule: cliCron, Run: idler.CLIIdler})
 	}
 	// Service Idler
 	if enableServiceIdler {
-		setupLog.Info("starting service idler")
-		_, err := c.AddFunc(serviceCron, func() {
-			idler.ServiceIdler()
-		})
+		cronJobs = append(cronJobs, scheduler.Job{Name: "service idler", Schedule: serviceCron, Run: idler.ServiceIdler})
+		// Schedule Idler, namespaces with a schedule need to be checked more often than the service idler runs
+		cronJobs = append(cronJobs, scheduler.Job{Name: "schedule idler", Schedule: scheduleCron, Run: idler.ScheduleIdler})
+	}
+	if err := mgr.Add(&scheduler.Scheduler{
+		Log:  ctrl.Log.WithName("aergia-controller").WithName("Scheduler"),
+		Jobs: cronJobs,
+	}); err != nil {
+		setupLog.Error(err, "unable to create idler scheduler")
+		os.Exit(1)
+	}
+
+	// reload the selectors and lists when they change, every replica watches its own files
+	if watchConfig {
+		if err := mgr.Add(&filewatcher.FileWatcher{
+			Name:  "selectors",
+			Files: []string{selectorsFile},
+			Log:   ctrl.Log.WithName("aergia-controller").WithName("SelectorsWatcher"),
+			Reload: func() error {
+				selectors, err := readSelectors(selectorsFile)
+				if err != nil {
+					return err
+				}
+				if skipHitCheck {
+					selectors.Service.SkipHitCheck = skipHitCheck
+				}
+				idler.SetSelectors(selectors)
+				return nil
+			},
+		}); err != nil {
+			setupLog.Error(err, "unable to create selectors watcher")
+			os.Exit(1)
+		}
+		listFiles := []string{}
+		for _, list := range []string{"allowedagents", "blockedagents", "allowedips", "blockedips"} {
+			listFiles = append(listFiles, filepath.Join(listsDir, list))
+		}
+		if err := mgr.Add(&filewatcher.FileWatcher{
+			Name:  "lists",
+			Files: listFiles,
+			Log:   ctrl.Log.WithName("aergia-controller").WithName("ListsWatcher"),
+			Reload: func() error {
+				accessLists, err := unidler.ReadAccessLists(listsDir)
+				if err != nil {
+					return err
+				}
+				u.SetAccessLists(accessLists)
+				return nil
+			},
+		}); err != nil {
+			setupLog.Error(err, "unable to create lists watcher")
+			os.Exit(1)
+		}
+	}
+
+	// the admin api uses the same TokenReview and SubjectAccessReview authentication as the metrics endpoint
+	if adminAPIAddr != "0" {
+		adminFilter, err := filters.WithAuthenticationAndAuthorization(mgr.GetConfig(), mgr.GetHTTPClient())
 		if err != nil {
-			setupLog.Error(err, "unable to create service idler cronjob", "controller", "Idling")
+			setupLog.Error(err, "unable to create admin api filter")
+			os.Exit(1)
+		}
+		if err := mgr.Add(&admin.Server{
+			Client:      mgr.GetClient(),
+			Idler:       idler,
+			Log:         ctrl.Log.WithName("aergia-controller").WithName("AdminAPI"),
+			BindAddress: adminAPIAddr,
+			CertDir:     adminAPICertDir,
+			Filter:      adminFilter,
+			Elected:     mgr.Elected(),
+		}); err != nil {
+			setupLog.Error(err, "unable to create admin api")
+			os.Exit(1)
 		}
 	}
-	// start crons.
-	c.Start()
 
 	// +kubebuilder:scaffold:builder
 	setupLog.Info("starting unidler listening")
@@ -278,23 +505,49 @@ func main() {
 		os.Exit(1)
 	}
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+	if enableIdlingPolicy {
+		setupLog.Info("watching idling policy", "name", idlingPolicyName)
+		if err = (&controllers.IdlingPolicyReconciler{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("controllers").WithName("IdlingPolicy"),
+			Scheme:     mgr.GetScheme(),
+			Idler:      idler,
+			PolicyName: idlingPolicyName,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "IdlingPolicy")
+			os.Exit(1)
+		}
 	}
-}
 
-func readSelectors(selectorsFile string) (*idler.Data, error) {
-	file, err := os.Open(selectorsFile)
-	if err != nil {
-		return nil, err
+	if enableIdlingFreeze {
+		setupLog.Info("watching idling freezes")
+		if err = (&controllers.IdlingFreezeReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("IdlingFreeze"),
+			Scheme: mgr.GetScheme(),
+			Idler:  idler,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "IdlingFreeze")
+			os.Exit(1)
+		}
+	}
+
+	setupLog.Info("starting manager")
+	err = mgr.Start(ctrl.SetupSignalHandler())
+	// finish sending any webhook notifications, including their retries
+	notifyCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := notify.Shutdown(notifyCtx); err != nil {
+		setupLog.Error(err, "unable to send all webhook notifications")
 	}
-	defer file.Close()
-	d := yaml.NewDecoder(file)
-	selectors := &idler.Data{}
-	if err := d.Decode(&selectors); err != nil {
-		return nil, err
+	cancel()
+	// flush any spans that haven't been exported yet
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+	cancel()
+	if err != nil {
+		setupLog.Error(err, "problem running manager")
+		os.Exit(1)
 	}
-	return selectors, nil
 }
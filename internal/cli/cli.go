@@ -0,0 +1,290 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	prometheusapi "github.com/prometheus/client_golang/api"
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	variables "github.com/uselagoon/machinery/utils/variables"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// command is an aergia subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, o *options, fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"status", "status [namespace]", status},
+	{"idle", "idle <namespace> [--force] [--force-scale]", idle},
+	{"unidle", "unidle <namespace> [--force]", unidle},
+	{"plan", "plan [--idler service|cli|schedule]", plan},
+	{"validate-selectors", "validate-selectors <file>", validateSelectors},
+}
+
+// IsCommand returns true if name is an aergia subcommand.
+func IsCommand(name string) bool {
+	for _, c := range commands {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// options are the flags shared by all the subcommands.
+type options struct {
+	stdout             io.Writer
+	stderr             io.Writer
+	kubeconfig         string
+	kubeContext        string
+	selectorsFile      string
+	idlingPolicyName   string
+	prometheusAddress  string
+	prometheusInterval time.Duration
+	podCheckInterval   time.Duration
+	minAwake           time.Duration
+	httpRouteBackend   string
+	output             string
+	debug              bool
+	// newClient is replaced in tests
+	newClient func(o *options) (client.Client, *rest.Config, error)
+}
+
+// Run runs an aergia subcommand, args[0] is the name of the command. It returns the exit code.
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	o := &options{stdout: stdout, stderr: stderr, newClient: newClient}
+	return o.run(ctx, args)
+}
+
+func (o *options) run(ctx context.Context, args []string) int {
+	for _, c := range commands {
+		if len(args) == 0 || c.name != args[0] {
+			continue
+		}
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		fs.SetOutput(o.stderr)
+		fs.StringVar(&o.kubeconfig, "kubeconfig", "", "The path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.")
+		fs.StringVar(&o.kubeContext, "context", "", "The kubeconfig context to use.")
+		fs.StringVar(&o.selectorsFile, "selectors", variables.GetEnv("SELECTORS_YAML_FILE", "resources/selectors.yaml"),
+			"The path to the file containing the label selectors for idling.")
+		fs.StringVar(&o.idlingPolicyName, "idling-policy-name", variables.GetEnv("IDLING_POLICY_NAME", "default"),
+			"The name of the IdlingPolicy resource to use for idling selectors if it exists. If empty, only the selectors file is used.")
+		fs.StringVar(&o.prometheusAddress, "prometheus-endpoint", variables.GetEnv("PROMETHEUS_ADDRESS", ""),
+			"The address for the prometheus endpoint to check hits against. If empty, hits are not checked.")
+		fs.DurationVar(&o.prometheusInterval, "prometheus-interval", envDuration("PROMETHEUS_CHECK_INTERVAL", 4*time.Hour),
+			"The time range interval for how long to check prometheus for.")
+		fs.DurationVar(&o.podCheckInterval, "pod-check-interval", envDuration("POD_CHECK_INTERVAL", 4*time.Hour),
+			"The time range interval for how long to check pod update.")
+		minAwake, _ := time.ParseDuration(variables.GetEnv("MIN_AWAKE_TIME", "0"))
+		fs.DurationVar(&o.minAwake, "min-awake-time", minAwake,
+			"The minimum time an environment stays awake after it is unidled before it can be idled again.")
+		fs.StringVar(&o.httpRouteBackend, "httproute-backend", variables.GetEnv("HTTPROUTE_BACKEND", ""),
+			"The aergia service that idled HTTPRoutes are redirected to, in the format namespace/name:port. If empty, HTTPRoutes are not idled.")
+		fs.StringVar(&o.output, "o", "table", "The output format, table or json.")
+		fs.BoolVar(&o.debug, "debug", false, "Log what aergia is doing to stderr.")
+		fs.Usage = func() {
+			fmt.Fprintf(o.stderr, "Usage: aergia %s\n", c.usage)
+			fs.PrintDefaults()
+		}
+		if err := c.run(ctx, o, fs, args[1:]); err != nil {
+			if err == flag.ErrHelp {
+				return 0
+			}
+			fmt.Fprintf(o.stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintln(o.stderr, "Usage: aergia <command> [flags]")
+	for _, c := range commands {
+		fmt.Fprintf(o.stderr, "  aergia %s\n", c.usage)
+	}
+	return 2
+}
+
+/*
+envDuration returns the duration in the envvar, or the default if it isn't set or can't be parsed. A number without a
+unit is a number of hours, as the controller still supports for the pod check interval of previous releases.
+*/
+func envDuration(name string, value time.Duration) time.Duration {
+	env := variables.GetEnv(name, "")
+	if env == "" {
+		return value
+	}
+	if d, err := time.ParseDuration(env); err == nil {
+		return d
+	}
+	if d, err := time.ParseDuration(fmt.Sprintf("%sh", env)); err == nil {
+		return d
+	}
+	return value
+}
+
+/*
+parseArgs parses the flags, which can come before or after the positional arguments, and returns the positional
+arguments. The command specific flags must be added to the flag set before it is called.
+*/
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// logger returns a logger that writes to stderr when debug is enabled.
+func (o *options) logger() logr.Logger {
+	if !o.debug {
+		return logr.Discard()
+	}
+	return zap.New(zap.WriteTo(o.stderr), zap.UseDevMode(true))
+}
+
+// newClient returns a client for the kubeconfig and context of the command, and the rest config it uses.
+func newClient(o *options) (client.Client, *rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: o.kubeContext}).ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load kubeconfig: %v", err)
+	}
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = idlingv1alpha1.AddToScheme(scheme)
+	_ = gatewayv1.Install(scheme)
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, config, nil
+}
+
+// newIdler returns an idler using the selectors file, and the prometheus endpoint if there is one. The rest config is
+// used to exec into pods, so it must be for the same cluster as the client.
+func (o *options) newIdler(c client.Client, config *rest.Config) (*idler.Idler, error) {
+	selectors, err := idler.ReadSelectors(o.selectorsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read selectors %s: %v", o.selectorsFile, err)
+	}
+	h := &idler.Idler{
+		Client:                  c,
+		RestConfig:              config,
+		Log:                     o.logger(),
+		PodCheckInterval:        o.podCheckInterval,
+		PrometheusCheckInterval: o.prometheusInterval,
+		MinAwake:                o.minAwake,
+		Selectors:               selectors,
+		Debug:                   o.debug,
+	}
+	if o.httpRouteBackend != "" {
+		h.HTTPRouteBackend, err = idler.ParseHTTPRouteBackend(o.httpRouteBackend)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode httproute backend: %v", err)
+		}
+	}
+	if o.prometheusAddress != "" {
+		prometheusClient, err := prometheusapi.NewClient(prometheusapi.Config{Address: o.prometheusAddress})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create prometheus client: %v", err)
+		}
+		h.HitSource = &idler.PrometheusHitSource{Client: prometheusClient, KubeClient: c, Selectors: h.GetSelectors}
+	}
+	return h, nil
+}
+
+/*
+loadPolicy replaces the selectors file with the selectors of the IdlingPolicy if it exists, in the same way as the
+controller does, so that commands use the selectors the controller is using. An invalid policy is ignored, as the
+controller keeps using the selectors it had.
+*/
+func (o *options) loadPolicy(ctx context.Context, c client.Client, h *idler.Idler) error {
+	if o.idlingPolicyName == "" {
+		return nil
+	}
+	policy := &idlingv1alpha1.IdlingPolicy{}
+	if err := c.Get(ctx, types.NamespacedName{Name: o.idlingPolicyName}, policy); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get idling policy %s: %v", o.idlingPolicyName, err)
+	}
+	selectors := idler.NewDataFromPolicy(policy.Spec)
+	if err := selectors.Validate(); err != nil {
+		fmt.Fprintf(o.stderr, "ignoring invalid idling policy %s, using the selectors file: %v\n", policy.Name, err)
+		return nil
+	}
+	h.SetPolicySelectors(selectors)
+	return nil
+}
+
+// loadFreezes adds the IdlingFreezes in the cluster to the idler, so plans skip the namespaces that are frozen. A
+// cluster without the IdlingFreeze resource has no freezes.
+func (o *options) loadFreezes(ctx context.Context, c client.Client, h *idler.Idler) error {
+	freezes := &idlingv1alpha1.IdlingFreezeList{}
+	if err := c.List(ctx, freezes); err != nil {
+		if meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get idling freezes: %v", err)
+	}
+	for _, freeze := range freezes.Items {
+		f, err := idler.NewFreeze(freeze)
+		if err != nil {
+			fmt.Fprintf(o.stderr, "ignoring invalid idling freeze %s: %v\n", freeze.Name, err)
+			continue
+		}
+		h.SetFreeze(f)
+	}
+	return nil
+}
+
+// writeJSON writes v as indented json.
+func (o *options) writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(o.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// checkOutput returns an error if the output format isn't supported.
+func (o *options) checkOutput() error {
+	switch o.output {
+	case "table", "json":
+		return nil
+	}
+	return fmt.Errorf("unsupported output format %s, must be table or json", o.output)
+}
+
+// oneNamespace returns the namespace argument of a command that needs exactly one.
+func oneNamespace(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected a namespace, got %q", strings.Join(args, " "))
+	}
+	return args[0], nil
+}
@@ -0,0 +1,310 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantCode     int
+		wantStdout   []string
+		wantStderr   string
+		wantReplicas *int32
+		objects      []client.Object
+	}{
+		{
+			name:       "test1",
+			args:       []string{"status", "--selectors", "testdata/selectors.yaml"},
+			wantStdout: []string{"NAMESPACE", "example-com-main", "example-com", "main"},
+		},
+		{
+			name:       "test2",
+			args:       []string{"status", "example-com-main", "--selectors", "testdata/selectors.yaml", "-o", "json"},
+			wantStdout: []string{`"name": "example-com-main"`, `"kind": "Deployment"`, `"replicas": 2`},
+		},
+		{
+			name:       "test3",
+			args:       []string{"status", "missing", "--selectors", "testdata/selectors.yaml"},
+			wantCode:   1,
+			wantStderr: `error: namespaces "missing" not found`,
+		},
+		{
+			name:         "test4",
+			args:         []string{"idle", "--selectors", "testdata/selectors.yaml", "example-com-main", "--force", "--dry-run"},
+			wantStdout:   []string{"example-com-main", "idle", "forced", "nginx"},
+			wantReplicas: int32Ptr(2),
+		},
+		{
+			name:         "test5",
+			args:         []string{"idle", "--selectors", "testdata/selectors.yaml", "example-com-main", "--force"},
+			wantStdout:   []string{"example-com-main", "idle", "forced", "nginx"},
+			wantReplicas: int32Ptr(0),
+		},
+		{
+			name:         "test6",
+			args:         []string{"idle", "--selectors", "testdata/selectors.yaml", "example-com-main"},
+			wantStdout:   []string{"example-com-main", "skip", "pod-age"},
+			wantReplicas: int32Ptr(2),
+		},
+		{
+			name:       "test7",
+			args:       []string{"idle", "--selectors", "testdata/selectors.yaml"},
+			wantCode:   1,
+			wantStderr: "error: expected a namespace",
+		},
+		{
+			name:       "test8",
+			args:       []string{"plan", "--selectors", "testdata/selectors.yaml", "-o", "json"},
+			wantStdout: []string{`"idler": "service"`, `"dryRun": true`, `"namespace": "example-com-main"`},
+		},
+		{
+			name:       "test9",
+			args:       []string{"plan", "--selectors", "testdata/selectors.yaml", "--idler", "builds"},
+			wantCode:   1,
+			wantStderr: "error: unknown idler builds",
+		},
+		{
+			name:       "test10",
+			args:       []string{"validate-selectors", "testdata/selectors.yaml"},
+			wantStdout: []string{"selectors in testdata/selectors.yaml are valid"},
+		},
+		{
+			name:       "test11",
+			args:       []string{"validate-selectors", "testdata/invalid-selectors.yaml"},
+			wantCode:   1,
+			wantStderr: "service.namespace",
+		},
+		{
+			name:       "test12",
+			args:       []string{"status", "--selectors", "testdata/selectors.yaml", "-o", "yaml"},
+			wantCode:   1,
+			wantStderr: "unsupported output format yaml",
+		},
+		{
+			name:       "test13",
+			args:       []string{"delete"},
+			wantCode:   2,
+			wantStderr: "Usage: aergia <command> [flags]",
+		},
+		{
+			name:       "test14",
+			args:       []string{"plan", "--selectors", "testdata/selectors.yaml"},
+			wantStdout: []string{"example-com-main", "skip", "frozen", "idling is frozen by release: release day"},
+			objects: []client.Object{
+				&idlingv1alpha1.IdlingFreeze{
+					ObjectMeta: metav1.ObjectMeta{Name: "release"},
+					Spec:       idlingv1alpha1.IdlingFreezeSpec{Reason: "release day"},
+				},
+			},
+		},
+		{
+			name:       "test15",
+			args:       []string{"plan", "--selectors", "testdata/selectors.yaml", "-o", "json"},
+			wantStdout: []string{`"namespaces": []`},
+			objects: []client.Object{
+				&idlingv1alpha1.IdlingPolicy{
+					ObjectMeta: metav1.ObjectMeta{Name: "default"},
+					Spec: idlingv1alpha1.IdlingPolicySpec{
+						Service: idlingv1alpha1.ServicePolicy{
+							Namespace: []idlingv1alpha1.Selector{
+								{Name: "lagoon.sh/environmentType", Operator: "in", Values: []string{"production"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "test16",
+			args:       []string{"unidle", "example-com-main"},
+			wantCode:   1,
+			wantStderr: "error: namespace example-com-main has been force scaled, use --force to unidle it",
+			objects: []client.Object{
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "php",
+						Namespace: "example-com-main",
+						Labels:    map[string]string{"idling.amazee.io/force-scaled": "true"},
+					},
+					Spec: appsv1.DeploymentSpec{
+						Replicas: int32Ptr(0),
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.objects...)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			o := &options{
+				stdout:    stdout,
+				stderr:    stderr,
+				newClient: func(*options) (client.Client, *rest.Config, error) { return c, &rest.Config{}, nil },
+			}
+			if code := o.run(context.Background(), tt.args); code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %s", code, tt.wantCode, stderr.String())
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout = %s, want it to contain %s", stdout.String(), want)
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %s, want it to contain %s", stderr.String(), tt.wantStderr)
+			}
+			if tt.wantReplicas != nil {
+				deployment := &appsv1.Deployment{}
+				if err := c.Get(context.Background(), types.NamespacedName{Namespace: "example-com-main", Name: "nginx"}, deployment); err != nil {
+					t.Fatal(err)
+				}
+				if *deployment.Spec.Replicas != *tt.wantReplicas {
+					t.Errorf("replicas = %d, want %d", *deployment.Spec.Replicas, *tt.wantReplicas)
+				}
+			}
+		})
+	}
+}
+
+func newTestClient(t *testing.T, objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := idlingv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithObjects(
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "example-com-main",
+				Labels: map[string]string{
+					"lagoon.sh/environmentType":     "development",
+					"lagoon.sh/project":             "example-com",
+					"lagoon.sh/environment":         "main",
+					"lagoon.sh/projectAutoIdle":     "1",
+					"lagoon.sh/environmentAutoIdle": "1",
+				},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx",
+				Namespace: "example-com-main",
+				Labels: map[string]string{
+					"idling.amazee.io/watch": "true",
+					"lagoon.sh/environment":  "main",
+					"lagoon.sh/service":      "nginx",
+				},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: int32Ptr(2),
+			},
+		},
+	).Build()
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestNewClient(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: first
+  cluster:
+    server: https://first.example.com
+- name: second
+  cluster:
+    server: https://second.example.com
+contexts:
+- name: first
+  context:
+    cluster: first
+    user: user
+- name: second
+  context:
+    cluster: second
+    user: user
+current-context: first
+users:
+- name: user
+  user:
+    token: token
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	o := &options{kubeconfig: kubeconfig, kubeContext: "second", selectorsFile: "testdata/selectors.yaml"}
+	c, config, err := newClient(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://second.example.com" {
+		t.Errorf("newClient() host = %s, want https://second.example.com", config.Host)
+	}
+	// the idler must exec into pods on the same cluster as the context of the command
+	h, err := o.newIdler(c, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.RestConfig != config {
+		t.Errorf("newIdler() rest config = %v, want the config of the command", h.RestConfig)
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{
+			name: "test1",
+			want: 4 * time.Hour,
+		},
+		{
+			name:  "test2",
+			value: "30m",
+			want:  30 * time.Minute,
+		},
+		{
+			name:  "test3",
+			value: "2",
+			want:  2 * time.Hour,
+		},
+		{
+			name:  "test4",
+			value: "sometimes",
+			want:  4 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POD_CHECK_INTERVAL", tt.value)
+			if got := envDuration("POD_CHECK_INTERVAL", 4*time.Hour); got != tt.want {
+				t.Errorf("envDuration() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uselagoon/aergia-controller/internal/handlers/admin"
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	"github.com/uselagoon/aergia-controller/internal/handlers/unidler"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// status shows the idle state of the namespaces aergia manages, or of a single namespace and its workloads.
+func status(ctx context.Context, o *options, fs *flag.FlagSet, args []string) error {
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := o.checkOutput(); err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("expected at most one namespace, got %d", len(args))
+	}
+	c, config, err := o.newClient(o)
+	if err != nil {
+		return err
+	}
+	h, err := o.newIdler(c, config)
+	if err != nil {
+		return err
+	}
+	if err := o.loadPolicy(ctx, c, h); err != nil {
+		return err
+	}
+	server := &admin.Server{Client: c, Idler: h, Log: o.logger()}
+	if len(args) == 0 {
+		namespaces, err := server.Namespaces(ctx)
+		if err != nil {
+			return err
+		}
+		if o.output == "json" {
+			return o.writeJSON(namespaces)
+		}
+		w := tabwriter.NewWriter(o.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tPROJECT\tENVIRONMENT\tIDLED\tFORCE IDLED\tFORCE SCALED")
+		for _, namespace := range namespaces {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%t\n", namespace.Name, orDash(namespace.Project), orDash(namespace.Environment),
+				namespace.Idled, namespace.ForceIdled, namespace.ForceScaled)
+		}
+		return w.Flush()
+	}
+	details, err := server.Namespace(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if o.output == "json" {
+		return o.writeJSON(details)
+	}
+	w := tabwriter.NewWriter(o.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Namespace:\t%s\n", details.Name)
+	fmt.Fprintf(w, "Project:\t%s\n", orDash(details.Project))
+	fmt.Fprintf(w, "Environment:\t%s\n", orDash(details.Environment))
+	fmt.Fprintf(w, "Idled:\t%t\n", details.Idled)
+	fmt.Fprintf(w, "Idled at:\t%s\n", orDash(details.IdledAt))
+	fmt.Fprintf(w, "Force idled:\t%t\n", details.ForceIdled)
+	fmt.Fprintf(w, "Force scaled:\t%t\n", details.ForceScaled)
+	switch {
+	case details.Hits != nil:
+		fmt.Fprintf(w, "Hits:\t%d in the last %s\n", *details.Hits, details.HitsInterval)
+	case details.HitsError != "":
+		fmt.Fprintf(w, "Hits:\t%s\n", details.HitsError)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(o.stdout)
+	w = tabwriter.NewWriter(o.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tNAME\tREPLICAS\tIDLED\tIDLED AT\tUNIDLE REPLICAS")
+	for _, workload := range details.Workloads {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%t\t%s\t%s\n", workload.Kind, workload.Name, workload.Replicas, workload.Idled,
+			orDash(workload.IdledAt), orDash(workload.UnidleReplicas))
+	}
+	return w.Flush()
+}
+
+// idle runs the service idler on a namespace and shows the decision it made.
+func idle(ctx context.Context, o *options, fs *flag.FlagSet, args []string) error {
+	forceIdle := fs.Bool("force", false, "Idle the namespace without checking the pod age or hits, the same as the force-idled label.")
+	forceScale := fs.Bool("force-scale", false, "Scale the namespace to zero without idling the ingress, the same as the force-scaled label.")
+	dryRun := fs.Bool("dry-run", false, "Show what would be idled without changing anything.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := o.checkOutput(); err != nil {
+		return err
+	}
+	name, err := oneNamespace(args)
+	if err != nil {
+		return err
+	}
+	c, config, err := o.newClient(o)
+	if err != nil {
+		return err
+	}
+	h, err := o.newIdler(c, config)
+	if err != nil {
+		return err
+	}
+	if err := o.loadPolicy(ctx, c, h); err != nil {
+		return err
+	}
+	h.DryRun = *dryRun
+	namespace := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, namespace); err != nil {
+		return err
+	}
+	started := time.Now()
+	nsPlan := h.IdleNamespace(ctx, o.logger(), *namespace, *forceIdle, *forceScale)
+	return o.writePlan(&idler.Plan{
+		Idler:      idler.ServiceIdlerName,
+		DryRun:     h.DryRun,
+		Started:    started,
+		Finished:   time.Now(),
+		Outcome:    idler.RunSucceeded,
+		Namespaces: []idler.NamespacePlan{nsPlan},
+	})
+}
+
+// unidle unidles a namespace and waits for its workloads to be ready. Force scaled namespaces are refused, in the same
+// way as requests to them, unless the unidle is forced.
+func unidle(ctx context.Context, o *options, fs *flag.FlagSet, args []string) error {
+	force := fs.Bool("force", false, "Unidle the namespace even if it has been force scaled.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	name, err := oneNamespace(args)
+	if err != nil {
+		return err
+	}
+	c, _, err := o.newClient(o)
+	if err != nil {
+		return err
+	}
+	namespace := &corev1.Namespace{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name}, namespace); err != nil {
+		return err
+	}
+	u := &unidler.Unidler{
+		Client:     c,
+		Log:        o.logger(),
+		Debug:      o.debug,
+		HTTPRoutes: o.httpRouteBackend != "",
+	}
+	if !*force && u.CheckForceScaled(ctx, namespace.Name, o.logger()) {
+		return fmt.Errorf("namespace %s has been force scaled, use --force to unidle it", namespace.Name)
+	}
+	switch u.Unidle(ctx, namespace, o.logger()) {
+	case unidler.UnidleReady:
+		fmt.Fprintf(o.stdout, "namespace %s unidled\n", namespace.Name)
+		return nil
+	case unidler.UnidleTimedOut:
+		return fmt.Errorf("namespace %s was unidled, but it was not ready within the unidle timeout", namespace.Name)
+	default:
+		return fmt.Errorf("unable to unidle namespace %s, its workloads or the namespace could not be patched", namespace.Name)
+	}
+}
+
+// plan runs an idler in dry run mode, and shows what it would idle without changing anything.
+func plan(ctx context.Context, o *options, fs *flag.FlagSet, args []string) error {
+	name := fs.String("idler", idler.ServiceIdlerName, "The idler to plan, service, cli or schedule.")
+	concurrency := fs.Int("concurrency", 1, "The number of namespaces to check at the same time.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := o.checkOutput(); err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	c, config, err := o.newClient(o)
+	if err != nil {
+		return err
+	}
+	h, err := o.newIdler(c, config)
+	if err != nil {
+		return err
+	}
+	if err := o.loadPolicy(ctx, c, h); err != nil {
+		return err
+	}
+	if err := o.loadFreezes(ctx, c, h); err != nil {
+		return err
+	}
+	h.DryRun = true
+	h.Concurrency = *concurrency
+	switch *name {
+	case idler.ServiceIdlerName:
+		h.ServiceIdler(ctx)
+	case idler.CLIIdlerName:
+		h.CLIIdler(ctx)
+	case idler.ScheduleIdlerName:
+		h.ScheduleIdler(ctx)
+	default:
+		return fmt.Errorf("unknown idler %s, must be service, cli or schedule", *name)
+	}
+	result := h.Plan(*name)
+	if result == nil {
+		return fmt.Errorf("the %s idler did not run", *name)
+	}
+	return o.writePlan(result)
+}
+
+// validateSelectors checks that a selectors file can be decoded and that its selectors are valid.
+func validateSelectors(_ context.Context, o *options, fs *flag.FlagSet, args []string) error {
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("expected a selectors file")
+	}
+	if _, err := idler.ReadSelectors(args[0]); err != nil {
+		return fmt.Errorf("selectors in %s are not valid: %v", args[0], err)
+	}
+	fmt.Fprintf(o.stdout, "selectors in %s are valid\n", args[0])
+	return nil
+}
+
+// writePlan writes a plan in the output format.
+func (o *options) writePlan(plan *idler.Plan) error {
+	if o.output == "json" {
+		return plan.WriteJSON(o.stdout)
+	}
+	return plan.WriteTable(o.stdout)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
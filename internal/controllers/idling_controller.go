@@ -19,9 +19,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
 	"github.com/uselagoon/aergia-controller/internal/handlers/unidler"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -46,12 +48,16 @@ func (r *IdlingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 	var namespace corev1.Namespace
 	if err := r.Get(ctx, req.NamespacedName, &namespace); err != nil {
+		if apierrors.IsNotFound(err) {
+			metrics.NamespaceStates.Delete(req.Name)
+		}
 		return ctrl.Result{}, ignoreNotFound(err)
 	}
+	r.recordState(namespace)
 
 	if val, ok := namespace.Labels["idling.amazee.io/force-scaled"]; ok && val == "true" {
 		opLog.Info(fmt.Sprintf("Force scaling environment %s", namespace.Name))
-		r.Idler.KubernetesServiceIdler(ctx, opLog, namespace, namespace.Labels[r.Idler.Selectors.NamespaceSelectorsLabels.ProjectName], false, true)
+		r.Idler.KubernetesServiceIdler(ctx, opLog, namespace, namespace.Labels[r.Idler.GetSelectors().NamespaceSelectorsLabels.ProjectName], false, true)
 		nsMergePatch, _ := json.Marshal(map[string]interface{}{
 			"metadata": map[string]interface{}{
 				"labels": map[string]*string{
@@ -68,7 +74,7 @@ func (r *IdlingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 	if val, ok := namespace.Labels["idling.amazee.io/force-idled"]; ok && val == "true" {
 		opLog.Info(fmt.Sprintf("Force idling environment %s", namespace.Name))
-		r.Idler.KubernetesServiceIdler(ctx, opLog, namespace, namespace.Labels[r.Idler.Selectors.NamespaceSelectorsLabels.ProjectName], true, false)
+		r.Idler.KubernetesServiceIdler(ctx, opLog, namespace, namespace.Labels[r.Idler.GetSelectors().NamespaceSelectorsLabels.ProjectName], true, false)
 		nsMergePatch, _ := json.Marshal(map[string]interface{}{
 			"metadata": map[string]interface{}{
 				"labels": map[string]*string{
@@ -102,6 +108,29 @@ func (r *IdlingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	return ctrl.Result{}, nil
 }
 
+/*
+recordState updates the idle state metrics of a namespace that aergia has idled or unidled. When and how it was idled
+are annotated on the namespace in the same patch that labels it as idled, as its deployments may not be scaled yet.
+*/
+func (r *IdlingReconciler) recordState(namespace corev1.Namespace) {
+	idled, ok := namespace.Labels["idling.amazee.io/idled"]
+	if !ok {
+		metrics.NamespaceStates.Delete(namespace.Name)
+		return
+	}
+	state := metrics.NamespaceState{
+		Project: namespace.Labels[r.Idler.GetSelectors().NamespaceSelectorsLabels.ProjectName],
+		Idled:   idled == "true",
+	}
+	if state.Idled {
+		state.ForceScaled = namespace.Annotations["idling.amazee.io/force-scaled"] == "true"
+		if t, err := time.Parse(time.RFC3339, namespace.Annotations["idling.amazee.io/idled-at"]); err == nil {
+			state.IdledAt = t
+		}
+	}
+	metrics.NamespaceStates.Set(namespace.Name, state)
+}
+
 // SetupWithManager sets up the watch on the namespace resource with an event filter (see predicates.go)
 func (r *IdlingReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
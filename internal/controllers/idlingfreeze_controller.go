@@ -0,0 +1,117 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// +kubebuilder:rbac:groups=idling.amazee.io,resources=idlingfreezes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=idling.amazee.io,resources=idlingfreezes/status,verbs=get;update;patch
+
+// IdlingFreezeReconciler reconciles IdlingFreeze resources into the freezes checked by the idler
+type IdlingFreezeReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+	Idler  *idler.Idler
+}
+
+func (r *IdlingFreezeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	opLog := r.Log.WithValues("idlingfreeze", req.Name)
+
+	var freeze idlingv1alpha1.IdlingFreeze
+	if err := r.Get(ctx, req.NamespacedName, &freeze); err != nil {
+		if apierrors.IsNotFound(err) {
+			opLog.Info("IdlingFreeze removed, idling is no longer frozen by it")
+			r.Idler.RemoveFreeze(req.Name)
+			metrics.IdlingFreezeActive.DeleteLabelValues(req.Name)
+		}
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	now := time.Now()
+	condition := metav1.Condition{
+		Type:               idlingv1alpha1.ConditionTypeActive,
+		ObservedGeneration: freeze.Generation,
+	}
+	result := ctrl.Result{}
+	f, err := idler.NewFreeze(freeze)
+	switch {
+	case err != nil:
+		// an invalid freeze doesn't freeze anything, rather than guessing which namespaces it should select
+		opLog.Info(fmt.Sprintf("IdlingFreeze is invalid, not applying: %v", err))
+		r.Idler.RemoveFreeze(freeze.Name)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	case f.Active(now):
+		opLog.Info(fmt.Sprintf("IdlingFreeze is active, %s", f))
+		r.Idler.SetFreeze(f)
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Active"
+		condition.Message = f.String()
+	case now.Before(f.Start):
+		r.Idler.SetFreeze(f)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Pending"
+		condition.Message = fmt.Sprintf("the freeze starts at %s", f.Start.UTC().Format(time.RFC3339))
+	default:
+		r.Idler.SetFreeze(f)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Ended"
+		condition.Message = fmt.Sprintf("the freeze ended at %s", f.End.UTC().Format(time.RFC3339))
+	}
+	if condition.Status == metav1.ConditionTrue {
+		metrics.IdlingFreezeActive.WithLabelValues(freeze.Name).Set(1)
+	} else {
+		metrics.IdlingFreezeActive.WithLabelValues(freeze.Name).Set(0)
+	}
+	// reconcile again when the freeze starts or ends, so that the status and metric follow it
+	if next := f.Next(now); err == nil && !next.IsZero() {
+		result.RequeueAfter = next.Sub(now)
+	}
+
+	freeze.Status.ObservedGeneration = freeze.Generation
+	meta.SetStatusCondition(&freeze.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, &freeze); err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// SetupWithManager sets up the watch on the idlingfreeze resource, status updates are ignored
+func (r *IdlingFreezeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idlingv1alpha1.IdlingFreeze{}).
+		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Complete(r)
+}
@@ -0,0 +1,99 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// +kubebuilder:rbac:groups=idling.amazee.io,resources=idlingpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=idling.amazee.io,resources=idlingpolicies/status,verbs=get;update;patch
+
+// IdlingPolicyReconciler reconciles IdlingPolicy resources into the selectors used by the idler
+type IdlingPolicyReconciler struct {
+	client.Client
+	Log        logr.Logger
+	Scheme     *runtime.Scheme
+	Idler      *idler.Idler
+	PolicyName string
+}
+
+func (r *IdlingPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	opLog := r.Log.WithValues("idlingpolicy", req.Name)
+
+	var policy idlingv1alpha1.IdlingPolicy
+	if err := r.Get(ctx, req.NamespacedName, &policy); err != nil {
+		if apierrors.IsNotFound(err) && req.Name == r.PolicyName {
+			// the active policy has been removed, go back to the selectors file
+			opLog.Info("IdlingPolicy removed, reverting to selectors file")
+			r.Idler.SetPolicySelectors(nil)
+		}
+		return ctrl.Result{}, ignoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:               idlingv1alpha1.ConditionTypeApplied,
+		ObservedGeneration: policy.Generation,
+	}
+	if policy.Name != r.PolicyName {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NotSelected"
+		condition.Message = fmt.Sprintf("only the IdlingPolicy named %s is used by the idler", r.PolicyName)
+	} else {
+		selectors := idler.NewDataFromPolicy(policy.Spec)
+		if err := selectors.Validate(); err != nil {
+			// keep using the previous selectors, a bad policy should never replace working selectors
+			opLog.Info(fmt.Sprintf("IdlingPolicy has invalid selectors, not applying: %v", err))
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "InvalidSelectors"
+			condition.Message = err.Error()
+		} else {
+			opLog.Info("Applying IdlingPolicy selectors")
+			r.Idler.SetPolicySelectors(selectors)
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "Applied"
+			condition.Message = "selectors are in use by the idler"
+		}
+	}
+
+	policy.Status.ObservedGeneration = policy.Generation
+	meta.SetStatusCondition(&policy.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, &policy); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the watch on the idlingpolicy resource, status updates are ignored
+func (r *IdlingPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idlingv1alpha1.IdlingPolicy{}).
+		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		Complete(r)
+}
@@ -0,0 +1,280 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// the labels that the idling controller watches to idle, force scale or unidle a namespace
+var actionLabels = map[string]string{
+	"idle":        "idling.amazee.io/force-idled",
+	"force-scale": "idling.amazee.io/force-scaled",
+	"unidle":      "idling.amazee.io/unidle",
+}
+
+// NamespaceStatus is the idle state of a namespace.
+type NamespaceStatus struct {
+	Name        string `json:"name"`
+	Project     string `json:"project,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Idled       bool   `json:"idled"`
+	ForceIdled  bool   `json:"forceIdled"`
+	ForceScaled bool   `json:"forceScaled"`
+}
+
+// NamespaceDetails is the idle state of a namespace, the workloads aergia manages in it, and the hits to it.
+type NamespaceDetails struct {
+	NamespaceStatus
+	IdledAt      string           `json:"idledAt,omitempty"`
+	Workloads    []WorkloadStatus `json:"workloads"`
+	Hits         *int             `json:"hits,omitempty"`
+	HitsInterval string           `json:"hitsInterval,omitempty"`
+	HitsError    string           `json:"hitsError,omitempty"`
+}
+
+// WorkloadStatus is the idle state of a deployment or statefulset.
+type WorkloadStatus struct {
+	Kind           string `json:"kind"`
+	Name           string `json:"name"`
+	Replicas       int32  `json:"replicas"`
+	Idled          bool   `json:"idled"`
+	IdledAt        string `json:"idledAt,omitempty"`
+	UnidleReplicas string `json:"unidleReplicas,omitempty"`
+}
+
+// Handler returns the admin api routes, without the authentication filter.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/namespaces", s.listNamespaces)
+	mux.HandleFunc("GET /api/v1/namespaces/{name}", s.getNamespace)
+	mux.HandleFunc("POST /api/v1/namespaces/{name}/{action}", s.namespaceAction)
+	mux.HandleFunc("POST /api/v1/idlers/{idler}/run", s.runIdler)
+	mux.HandleFunc("GET /api/v1/idlers/{idler}/plan", s.getPlan)
+	return mux
+}
+
+func (s *Server) listNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := s.Namespaces(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	statuses := []NamespaceStatus{}
+	for _, status := range namespaces {
+		if idled := r.URL.Query().Get("idled"); idled != "" && idled != fmt.Sprint(status.Idled) {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) getNamespace(w http.ResponseWriter, r *http.Request) {
+	details, err := s.Namespace(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// Namespaces returns the idle state of the namespaces the service idler checks.
+func (s *Server) Namespaces(ctx context.Context) ([]NamespaceStatus, error) {
+	selector, err := s.Idler.NamespaceSelector()
+	if err != nil {
+		return nil, err
+	}
+	namespaces := &corev1.NamespaceList{}
+	if err := s.Client.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	statuses := []NamespaceStatus{}
+	for _, namespace := range namespaces.Items {
+		statuses = append(statuses, s.namespaceStatus(namespace))
+	}
+	return statuses, nil
+}
+
+// Namespace returns the idle state of a namespace, the workloads aergia manages in it, and the hits to it.
+func (s *Server) Namespace(ctx context.Context, name string) (*NamespaceDetails, error) {
+	namespace := &corev1.Namespace{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: name}, namespace); err != nil {
+		return nil, err
+	}
+	details := &NamespaceDetails{
+		NamespaceStatus: s.namespaceStatus(*namespace),
+		Workloads:       []WorkloadStatus{},
+	}
+	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
+		client.InNamespace(namespace.Name),
+		client.MatchingLabels{"idling.amazee.io/watch": "true"},
+	})
+	deployments := &appsv1.DeploymentList{}
+	if err := s.Client.List(ctx, deployments, listOption); err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		details.Workloads = append(details.Workloads, workloadStatus("Deployment", deployment.ObjectMeta.Name,
+			deployment.Spec.Replicas, deployment.Labels, deployment.Annotations))
+	}
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := s.Client.List(ctx, statefulSets, listOption); err != nil {
+		return nil, err
+	}
+	for _, statefulSet := range statefulSets.Items {
+		details.Workloads = append(details.Workloads, workloadStatus("StatefulSet", statefulSet.ObjectMeta.Name,
+			statefulSet.Spec.Replicas, statefulSet.Labels, statefulSet.Annotations))
+	}
+	// the namespace was idled when the last of its workloads was idled
+	var idledAt time.Time
+	for _, workload := range details.Workloads {
+		if t, err := time.Parse(time.RFC3339, workload.IdledAt); err == nil && t.After(idledAt) {
+			idledAt = t
+			details.IdledAt = workload.IdledAt
+		}
+	}
+	if s.Idler.HitSource != nil {
+		details.HitsInterval = s.Idler.PrometheusCheckInterval.String()
+		hits, err := s.Idler.HitSource.Hits(ctx, s.Log.WithValues("namespace", namespace.Name), *namespace, s.Idler.PrometheusCheckInterval)
+		if err != nil {
+			details.HitsError = err.Error()
+		} else {
+			details.Hits = &hits
+		}
+	}
+	return details, nil
+}
+
+// namespaceAction labels the namespace so that the idling controller idles, force scales, or unidles it.
+func (s *Server) namespaceAction(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	label, ok := actionLabels[action]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %s", action))
+		return
+	}
+	namespace := &corev1.Namespace{}
+	if err := s.Client.Get(r.Context(), types.NamespacedName{Name: r.PathValue("name")}, namespace); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	mergePatch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				label: "true",
+			},
+		},
+	})
+	if err := s.Client.Patch(r.Context(), namespace, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+		writeError(w, statusCode(err), err)
+		return
+	}
+	s.Log.Info(fmt.Sprintf("Namespace %s labelled to %s", namespace.Name, action))
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"namespace": namespace.Name,
+		"action":    action,
+	})
+}
+
+// runIdler starts the service or cli idler in the background, only the leader can run the idlers.
+func (s *Server) runIdler(w http.ResponseWriter, r *http.Request) {
+	var run func(ctx context.Context)
+	switch r.PathValue("idler") {
+	case idler.ServiceIdlerName:
+		run = s.Idler.ServiceIdler
+	case idler.CLIIdlerName:
+		run = s.Idler.CLIIdler
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown idler %s", r.PathValue("idler")))
+		return
+	}
+	if !s.leader() {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("the idlers only run on the leader replica"))
+		return
+	}
+	if s.Idler.IsRunning(r.PathValue("idler")) {
+		writeError(w, http.StatusConflict, fmt.Errorf("the %s idler is already running", r.PathValue("idler")))
+		return
+	}
+	s.Log.Info(fmt.Sprintf("Running the %s idler", r.PathValue("idler")))
+	go run(s.runContext())
+	writeJSON(w, http.StatusAccepted, map[string]string{
+		"idler": r.PathValue("idler"),
+	})
+}
+
+// getPlan returns the plan of the last run of an idler, as json or as a table if `?format=table` is requested.
+func (s *Server) getPlan(w http.ResponseWriter, r *http.Request) {
+	if !s.leader() {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("plans are only available from the leader replica"))
+		return
+	}
+	plan := s.Idler.Plan(r.PathValue("idler"))
+	if plan == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no plan for the %s idler", r.PathValue("idler")))
+		return
+	}
+	if r.URL.Query().Get("format") == "table" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_ = plan.WriteTable(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = plan.WriteJSON(w)
+}
+
+func (s *Server) namespaceStatus(namespace corev1.Namespace) NamespaceStatus {
+	selectors := s.Idler.GetSelectors()
+	return NamespaceStatus{
+		Name:        namespace.Name,
+		Project:     namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName],
+		Environment: namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentName],
+		Idled:       namespace.Labels["idling.amazee.io/idled"] == "true",
+		ForceIdled:  namespace.Labels["idling.amazee.io/force-idled"] == "true",
+		ForceScaled: namespace.Labels["idling.amazee.io/force-scaled"] == "true",
+	}
+}
+
+func workloadStatus(kind, name string, replicas *int32, workloadLabels labels.Set, annotations map[string]string) WorkloadStatus {
+	status := WorkloadStatus{
+		Kind:           kind,
+		Name:           name,
+		Idled:          workloadLabels["idling.amazee.io/idled"] == "true",
+		IdledAt:        annotations["idling.amazee.io/idled-at"],
+		UnidleReplicas: annotations["idling.amazee.io/unidle-replicas"],
+	}
+	if replicas != nil {
+		status.Replicas = *replicas
+	}
+	return status
+}
+
+func statusCode(err error) int {
+	if apierrors.IsNotFound(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
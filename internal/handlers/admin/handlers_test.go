@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServer_Handler(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantBody  string
+		wantLabel string
+		follower  bool
+	}{
+		{
+			name:     "test1",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces",
+			wantCode: http.StatusOK,
+			wantBody: `[{"name":"example-com-main","project":"example-com","environment":"main","idled":true,"forceIdled":false,"forceScaled":false}]`,
+		},
+		{
+			name:     "test2",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces?idled=false",
+			wantCode: http.StatusOK,
+			wantBody: `[]`,
+		},
+		{
+			name:     "test3",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/example-com-main",
+			wantCode: http.StatusOK,
+			wantBody: `{"name":"example-com-main","project":"example-com","environment":"main","idled":true,"forceIdled":false,"forceScaled":false,` +
+				`"idledAt":"2026-01-02T03:04:05Z","workloads":[{"kind":"Deployment","name":"nginx","replicas":0,"idled":true,` +
+				`"idledAt":"2026-01-02T03:04:05Z","unidleReplicas":"2"}],"hits":4,"hitsInterval":"4h0m0s"}`,
+		},
+		{
+			name:     "test4",
+			method:   http.MethodGet,
+			path:     "/api/v1/namespaces/missing",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:      "test5",
+			method:    http.MethodPost,
+			path:      "/api/v1/namespaces/example-com-main/force-scale",
+			wantCode:  http.StatusAccepted,
+			wantLabel: "idling.amazee.io/force-scaled",
+		},
+		{
+			name:      "test6",
+			method:    http.MethodPost,
+			path:      "/api/v1/namespaces/example-com-main/unidle",
+			wantCode:  http.StatusAccepted,
+			wantLabel: "idling.amazee.io/unidle",
+		},
+		{
+			name:     "test7",
+			method:   http.MethodPost,
+			path:     "/api/v1/namespaces/example-com-main/delete",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "test8",
+			method:   http.MethodPost,
+			path:     "/api/v1/idlers/builds/run",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "test9",
+			method:   http.MethodDelete,
+			path:     "/api/v1/namespaces/example-com-main",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "test10",
+			method:   http.MethodGet,
+			path:     "/api/v1/idlers/service/plan",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "test11",
+			method:   http.MethodPost,
+			path:     "/api/v1/idlers/service/run",
+			wantCode: http.StatusServiceUnavailable,
+			follower: true,
+		},
+		{
+			name:     "test12",
+			method:   http.MethodGet,
+			path:     "/api/v1/idlers/service/plan",
+			wantCode: http.StatusServiceUnavailable,
+			follower: true,
+		},
+		{
+			name:      "test13",
+			method:    http.MethodPost,
+			path:      "/api/v1/namespaces/example-com-main/unidle",
+			wantCode:  http.StatusAccepted,
+			wantLabel: "idling.amazee.io/unidle",
+			follower:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			if tt.follower {
+				// a replica that hasn't been elected leader
+				s.Elected = make(chan struct{})
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantBody != "" {
+				var got, want interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal([]byte(tt.wantBody), &want); err != nil {
+					t.Fatal(err)
+				}
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(want)
+				if string(gotJSON) != string(wantJSON) {
+					t.Errorf("body = %s, want %s", gotJSON, wantJSON)
+				}
+			}
+			if tt.wantLabel != "" {
+				namespace := &corev1.Namespace{}
+				if err := s.Client.Get(context.Background(), types.NamespacedName{Name: "example-com-main"}, namespace); err != nil {
+					t.Fatal(err)
+				}
+				if namespace.Labels[tt.wantLabel] != "true" {
+					t.Errorf("namespace labels = %v, want %s=true", namespace.Labels, tt.wantLabel)
+				}
+			}
+		})
+	}
+}
+
+func newTestServer(t *testing.T) *Server {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open("testdata/selectors.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	selectors := &idler.Data{}
+	if err := yaml.NewDecoder(file).Decode(selectors); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(0)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "example-com-main",
+				Labels: map[string]string{
+					"lagoon.sh/environmentType": "development",
+					"lagoon.sh/project":         "example-com",
+					"lagoon.sh/environment":     "main",
+					"idling.amazee.io/idled":    "true",
+				},
+			},
+		},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "example-com-production",
+				Labels: map[string]string{
+					"lagoon.sh/environmentType": "production",
+				},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx",
+				Namespace: "example-com-main",
+				Labels: map[string]string{
+					"idling.amazee.io/watch": "true",
+					"idling.amazee.io/idled": "true",
+				},
+				Annotations: map[string]string{
+					"idling.amazee.io/idled-at":        "2026-01-02T03:04:05Z",
+					"idling.amazee.io/unidle-replicas": "2",
+				},
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+			},
+		},
+	).Build()
+	return &Server{
+		Client: c,
+		Log:    logr.Discard(),
+		Idler: &idler.Idler{
+			Client:                  c,
+			Log:                     logr.Discard(),
+			Selectors:               selectors,
+			HitSource:               &idler.StaticHitSource{DefaultHits: 4},
+			PrometheusCheckInterval: 4 * time.Hour,
+		},
+	}
+}
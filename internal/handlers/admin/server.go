@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/idler"
+	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+// Server is the admin api, it is served over https and every request is authenticated and authorized by the Filter.
+type Server struct {
+	Client      client.Client
+	Idler       *idler.Idler
+	Log         logr.Logger
+	BindAddress string
+	// CertDir contains the tls.crt and tls.key to serve, if they don't exist a self-signed certificate is used.
+	CertDir string
+	// Filter authenticates and authorizes requests, usually with a TokenReview and SubjectAccessReview.
+	Filter metricsserver.Filter
+	// Elected is closed when this replica is elected leader, a nil channel is treated as the leader.
+	Elected <-chan struct{}
+	// ctx is the context the api was started with, idler runs are cancelled with it.
+	ctx context.Context
+}
+
+// NeedLeaderElection returns false so that the api is available on every replica.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+/*
+leader returns true if this replica is the leader. Only the leader runs the policy and freeze controllers and the idler
+crons, so idler runs and plans are only available from the leader.
+*/
+func (s *Server) leader() bool {
+	if s.Elected == nil {
+		return true
+	}
+	select {
+	case <-s.Elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// runContext returns the context that idler runs started by the api are cancelled with.
+func (s *Server) runContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Start serves the admin api until the context is done.
+func (s *Server) Start(ctx context.Context) error {
+	if s.Filter == nil {
+		return fmt.Errorf("the admin api requires an authentication filter")
+	}
+	s.ctx = ctx
+	handler, err := s.Filter(s.Log, s.Handler())
+	if err != nil {
+		return fmt.Errorf("unable to create the admin api filter: %v", err)
+	}
+	tlsConfig, err := s.tlsConfig(ctx)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.Log.Error(err, "error shutting down the admin api")
+		}
+	}()
+	s.Log.Info(fmt.Sprintf("Starting the admin api on %s", s.BindAddress))
+	if err := srv.Serve(tls.NewListener(listener, tlsConfig)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+	}
+	certPath := filepath.Join(s.CertDir, "tls.crt")
+	keyPath := filepath.Join(s.CertDir, "tls.key")
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if s.CertDir != "" && certErr == nil && keyErr == nil {
+		watcher, err := certwatcher.New(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			if err := watcher.Start(ctx); err != nil {
+				s.Log.Error(err, "admin api certificate watcher error")
+			}
+		}()
+		cfg.GetCertificate = watcher.GetCertificate
+		return cfg, nil
+	}
+	cert, key, err := certutil.GenerateSelfSignedCertKeyWithFixtures("localhost", []net.IP{{127, 0, 0, 1}}, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate a self-signed certificate for the admin api: %v", err)
+	}
+	keyPair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Certificates = []tls.Certificate{keyPair}
+	return cfg, nil
+}
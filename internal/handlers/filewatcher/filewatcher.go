@@ -0,0 +1,106 @@
+package filewatcher
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+)
+
+const defaultDebounce = 2 * time.Second
+
+// FileWatcher calls Reload when any of the watched files change. The directories containing the files are watched rather
+// than the files themselves, so that files mounted from a ConfigMap are reloaded when kubernetes replaces them.
+type FileWatcher struct {
+	Name     string
+	Files    []string
+	Log      logr.Logger
+	Reload   func() error
+	Debounce time.Duration
+}
+
+// NeedLeaderElection is false, every replica needs to reload its own config.
+func (w *FileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the files until the context is cancelled.
+func (w *FileWatcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create watcher for %s: %v", w.Name, err)
+	}
+	defer watcher.Close()
+	dirs := map[string]bool{}
+	for _, file := range w.Files {
+		dir := filepath.Dir(file)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			// the directory may not exist if the files are optional, there is nothing to reload
+			w.Log.Info(fmt.Sprintf("Unable to watch %s for %s changes: %v", dir, w.Name, err))
+			continue
+		}
+		dirs[dir] = true
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+	debounce := w.Debounce
+	if debounce == 0 {
+		debounce = defaultDebounce
+	}
+	// changes usually come in bursts, so wait until the files have settled before reloading
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if w.watched(event.Name) {
+				timer.Reset(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.Log.Error(err, fmt.Sprintf("Error watching %s", w.Name))
+		case <-timer.C:
+			w.reload()
+		}
+	}
+}
+
+// watched checks if the event is for one of the files, or the data directory of a ConfigMap volume.
+func (w *FileWatcher) watched(name string) bool {
+	if strings.HasPrefix(filepath.Base(name), "..") {
+		return true
+	}
+	for _, file := range w.Files {
+		if filepath.Clean(file) == filepath.Clean(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *FileWatcher) reload() {
+	if err := w.Reload(); err != nil {
+		metrics.ConfigReloads.WithLabelValues(w.Name, "failure").Inc()
+		w.Log.Error(err, fmt.Sprintf("Unable to reload %s, keeping the previous %s", w.Name, w.Name))
+		return
+	}
+	metrics.ConfigReloads.WithLabelValues(w.Name, "success").Inc()
+	w.Log.Info(fmt.Sprintf("Reloaded %s", w.Name))
+}
@@ -0,0 +1,72 @@
+package filewatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "blockedips")
+	if err := os.WriteFile(file, []byte("1.2.3.4\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan string, 10)
+	w := &FileWatcher{
+		Name:     "lists",
+		Files:    []string{file},
+		Log:      logr.Discard(),
+		Debounce: 10 * time.Millisecond,
+		Reload: func() error {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			reloaded <- string(b)
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- w.Start(ctx)
+	}()
+	// give the watcher time to start, then change an unrelated file and the watched file
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(dir, "unrelated"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("5.6.7.8\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-reloaded:
+		if got != "5.6.7.8\n" {
+			t.Errorf("reloaded %q, want %q", got, "5.6.7.8\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("file was not reloaded")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileWatcherMissingDirectory(t *testing.T) {
+	w := &FileWatcher{
+		Name:   "lists",
+		Files:  []string{filepath.Join(t.TempDir(), "missing", "blockedips")},
+		Log:    logr.Discard(),
+		Reload: func() error { return nil },
+	}
+	if err := w.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package idler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// UnidledAtAnnotation is added to a namespace by the unidler with the time it was unidled.
+const UnidledAtAnnotation = "idling.amazee.io/unidled-at"
+
+// MinAwakeAnnotation overrides the minimum awake time of a namespace.
+const MinAwakeAnnotation = "idling.amazee.io/min-awake"
+
+/*
+awakeRemaining returns how long until a namespace that was unidled has been awake for the minimum awake time, and the
+minimum awake time used. The namespace annotation overrides the minimum awake time of the idler, a namespace that has
+never been unidled by aergia can be idled at any time.
+*/
+func (h *Idler) awakeRemaining(namespace corev1.Namespace, now time.Time, opLog logr.Logger) (time.Duration, time.Duration) {
+	minAwake := h.MinAwake
+	if value, ok := namespace.Annotations[MinAwakeAnnotation]; ok {
+		t, err := time.ParseDuration(value)
+		if err != nil || t < 0 {
+			opLog.Info(fmt.Sprintf("Invalid %s annotation %s, using the default of %s", MinAwakeAnnotation, value, h.MinAwake))
+		} else {
+			minAwake = t
+		}
+	}
+	if minAwake <= 0 {
+		return 0, minAwake
+	}
+	value, ok := namespace.Annotations[UnidledAtAnnotation]
+	if !ok {
+		return 0, minAwake
+	}
+	unidledAt, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		opLog.Info(fmt.Sprintf("Invalid %s annotation %s, ignoring it", UnidledAtAnnotation, value))
+		return 0, minAwake
+	}
+	return unidledAt.Add(minAwake).Sub(now), minAwake
+}
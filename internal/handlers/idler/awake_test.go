@@ -0,0 +1,104 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+)
+
+func TestKubernetesServiceIdlerMinAwake(t *testing.T) {
+	tests := []struct {
+		name        string
+		minAwake    time.Duration
+		annotations map[string]string
+		forceIdle   bool
+		wantIdled   bool
+		wantEvent   string
+	}{
+		{
+			name:        "test1",
+			minAwake:    time.Hour,
+			annotations: map[string]string{UnidledAtAnnotation: time.Now().Add(-10 * time.Minute).Format(time.RFC3339)},
+			wantIdled:   false,
+			wantEvent:   "Normal SkippedMinAwake Environment not idled, it was unidled less than 1h0m0s ago",
+		},
+		{
+			name:        "test2",
+			minAwake:    time.Hour,
+			annotations: map[string]string{UnidledAtAnnotation: time.Now().Add(-2 * time.Hour).Format(time.RFC3339)},
+			wantIdled:   true,
+		},
+		{
+			name:        "test3",
+			annotations: map[string]string{UnidledAtAnnotation: time.Now().Add(-10 * time.Minute).Format(time.RFC3339)},
+			wantIdled:   true,
+		},
+		{
+			name: "test4",
+			annotations: map[string]string{
+				UnidledAtAnnotation: time.Now().Add(-10 * time.Minute).Format(time.RFC3339),
+				MinAwakeAnnotation:  "30m",
+			},
+			wantIdled: false,
+			wantEvent: "Normal SkippedMinAwake Environment not idled, it was unidled less than 30m0s ago",
+		},
+		{
+			name:     "test5",
+			minAwake: time.Hour,
+			annotations: map[string]string{
+				UnidledAtAnnotation: time.Now().Add(-10 * time.Minute).Format(time.RFC3339),
+				MinAwakeAnnotation:  "5m",
+			},
+			wantIdled: true,
+		},
+		{
+			name:        "test6",
+			minAwake:    time.Hour,
+			annotations: map[string]string{UnidledAtAnnotation: time.Now().Add(-10 * time.Minute).Format(time.RFC3339)},
+			forceIdle:   true,
+			wantIdled:   true,
+		},
+		{
+			name:      "test7",
+			minAwake:  time.Hour,
+			wantIdled: true,
+		},
+		{
+			name:        "test8",
+			minAwake:    time.Hour,
+			annotations: map[string]string{UnidledAtAnnotation: "yesterday"},
+			wantIdled:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectors := readTestSelectors(t, "testdata/valid-selectors.yaml")
+			h, namespace := newTestIdler(t, selectors, &StaticHitSource{})
+			h.MinAwake = tt.minAwake
+			namespace.Annotations = tt.annotations
+			h.KubernetesServiceIdler(context.Background(), logr.Discard(), namespace, "example-com", tt.forceIdle, false)
+			deployment := &appsv1.Deployment{}
+			if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.Name, Name: "nginx"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			if (*deployment.Spec.Replicas == 0) != tt.wantIdled {
+				t.Errorf("deployment replicas = %d, want idled %v", *deployment.Spec.Replicas, tt.wantIdled)
+			}
+			if tt.wantEvent != "" {
+				select {
+				case event := <-h.Recorder.(*events.FakeRecorder).Events:
+					if event != tt.wantEvent {
+						t.Errorf("event = %q, want %q", event, tt.wantEvent)
+					}
+				default:
+					t.Errorf("event not recorded, want %q", tt.wantEvent)
+				}
+			}
+		})
+	}
+}
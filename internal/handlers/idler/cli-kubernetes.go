@@ -10,6 +10,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	"github.com/uselagoon/aergia-controller/internal/handlers/recorder"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -19,7 +20,14 @@ import (
 
 // kubernetesCLI handles scaling CLI based deployments in kubernetes.
 func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace) {
-	labelRequirements := generateLabelRequirements(h.Selectors.CLI.Builds)
+	plan := planFor(ctx)
+	selectors := h.GetSelectors()
+	labelRequirements, err := generateLabelRequirements(selectors.CLI.Builds)
+	if err != nil {
+		opLog.Error(err, "Error generating build selectors")
+		plan.decide(DecisionError, PlanReasonError, "error generating build selectors: %v", err)
+		return
+	}
 	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 		client.InNamespace(namespace.Name),
 		client.MatchingLabelsSelector{
@@ -29,7 +37,7 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 
 	builds := &corev1.PodList{}
 	runningBuild := false
-	if !h.Selectors.CLI.SkipBuildCheck {
+	if !selectors.CLI.SkipBuildCheck {
 		if err := h.Client.List(ctx, builds, listOption); err != nil {
 			opLog.Error(err, fmt.Sprintf("Error getting running builds for namespace %s", namespace.Name))
 		} else {
@@ -42,10 +50,20 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 			}
 		}
 	}
+	if runningBuild {
+		recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeNormal, recorder.ReasonSkippedRunningBuild, recorder.ActionSkip,
+			"CLI not idled, a build is running")
+		plan.decide(DecisionSkip, PlanReasonRunningBuild, "a build is running")
+	}
 	// if there are no running builds, then check the cli pods
 	if !runningBuild {
 		// @TODO: eventually replace the `lagoon.sh/service=cli` check with `lagoon.sh/service-type=cli|cli-persistent` for better coverage
-		labelRequirements := generateLabelRequirements(h.Selectors.CLI.Deployments)
+		labelRequirements, err := generateLabelRequirements(selectors.CLI.Deployments)
+		if err != nil {
+			opLog.Error(err, "Error generating deployment selectors")
+			plan.decide(DecisionError, PlanReasonError, "error generating deployment selectors: %v", err)
+			return
+		}
 		listOption = (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 			client.InNamespace(namespace.Name),
 			client.MatchingLabelsSelector{
@@ -55,6 +73,7 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 		deployments := &appsv1.DeploymentList{}
 		if err := h.Client.List(ctx, deployments, listOption); err != nil {
 			opLog.Error(err, "Error getting deployments")
+			plan.decide(DecisionError, PlanReasonError, "error getting deployments: %v", err)
 		} else {
 			for _, deployment := range deployments.Items {
 				// if we have any services=cli, act on them
@@ -64,6 +83,7 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 					opLog.Info(fmt.Sprintf("Deployment %s has %d running replicas", deployment.Name, *deployment.Spec.Replicas))
 				} else {
 					opLog.Info(fmt.Sprintf("Deployment %s is already idled", deployment.Name))
+					plan.decide(DecisionSkip, PlanReasonAlreadyIdled, "deployment %s is already idled", deployment.Name)
 					break
 				}
 				if h.Debug {
@@ -71,7 +91,7 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 				}
 
 				hasCrons := false
-				if !h.Selectors.CLI.SkipCronCheck {
+				if !selectors.CLI.SkipCronCheck {
 					for _, container := range deployment.Spec.Template.Spec.Containers {
 						for _, env := range container.Env {
 							if env.Name == "CRONJOBS" {
@@ -85,9 +105,19 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 						}
 					}
 				}
+				if hasCrons {
+					recorder.Object(h.Recorder, &deployment, corev1.EventTypeNormal, recorder.ReasonSkippedCronJobs, recorder.ActionSkip,
+						"CLI not idled, it has cronjobs defined")
+					plan.decide(DecisionSkip, PlanReasonCronJobs, "deployment %s has cronjobs defined", deployment.Name)
+				}
 				if !hasCrons {
 					pods := &corev1.PodList{}
-					labelRequirements := generateLabelRequirements(h.Selectors.CLI.Pods)
+					labelRequirements, err := generateLabelRequirements(selectors.CLI.Pods)
+					if err != nil {
+						opLog.Error(err, "Error generating pod selectors")
+						plan.decide(DecisionError, PlanReasonError, "error generating pod selectors: %v", err)
+						return
+					}
 					listOption = (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 						client.InNamespace(namespace.Name),
 						client.MatchingLabelsSelector{
@@ -96,10 +126,11 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 					})
 					if err := h.Client.List(ctx, pods, listOption); err != nil {
 						opLog.Error(err, "Error listing pods")
+						plan.decide(DecisionError, PlanReasonError, "error listing pods: %v", err)
 					} else {
 						for _, pod := range pods.Items {
 							processCount := 0
-							if !h.Selectors.CLI.SkipProcessCheck {
+							if !selectors.CLI.SkipProcessCheck {
 								if h.Debug {
 									opLog.Info(fmt.Sprintf("Checking pod %s for running processes", pod.Name))
 								}
@@ -108,9 +139,10 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 									/bin/bash -c "pgrep -P 0 | tail -n +3 | wc -l | tr -d ' '"
 								*/
 								var stdin io.Reader
-								stdout, _, err := execPod(pod.Name, namespace.Name, []string{`/bin/sh`, `-c`, `pgrep -P 0|tail -n +3|wc -l|tr -d ' '`}, stdin, false)
+								stdout, _, err := h.execPod(ctx, pod.Name, namespace.Name, []string{`/bin/sh`, `-c`, `pgrep -P 0|tail -n +3|wc -l|tr -d ' '`}, stdin, false)
 								if err != nil {
 									opLog.Error(err, fmt.Sprintf("Error when trying to exec to pod %s", pod.Name))
+									plan.decide(DecisionError, PlanReasonError, "error checking pod %s for running processes: %v", pod.Name, err)
 									break
 								}
 								trimmed := strings.TrimSpace(string(stdout))
@@ -124,7 +156,15 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 									opLog.Info(fmt.Sprintf("Pod %s has no running processes, idling", pod.Name))
 								}
 							}
+							if processCount > 0 {
+								plan.decide(DecisionSkip, PlanReasonProcesses, "pod %s has %d running processes", pod.Name, processCount)
+							}
 							if processCount == 0 {
+								message := fmt.Sprintf("pod %s has no running processes", pod.Name)
+								if selectors.CLI.SkipProcessCheck {
+									message = "the process check is skipped"
+								}
+								plan.decide(DecisionIdle, PlanReasonNoProcesses, "%s", message)
 								if !h.DryRun {
 									scaleDeployment := deployment.DeepCopy()
 									mergePatch, _ := json.Marshal(map[string]interface{}{
@@ -136,10 +176,16 @@ func (h *Idler) kubernetesCLI(ctx context.Context, opLog logr.Logger, namespace
 										opLog.Error(err, fmt.Sprintf("Error scaling deployment %s", deployment.Name))
 									} else {
 										opLog.Info(fmt.Sprintf("Deployment %s scaled to 0", deployment.Name))
+										plan.touch(planDeployment, deployment.Name)
+										recorder.Object(h.Recorder, &deployment, corev1.EventTypeNormal, recorder.ReasonIdled, recorder.ActionIdle,
+											"CLI scaled to 0 replicas, it has no running processes")
+										recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeNormal, recorder.ReasonIdled, recorder.ActionIdle,
+											"CLI deployment %s idled, it has no running processes", deployment.Name)
 									}
 									metrics.CliIdleEvents.Inc()
 								} else {
 									opLog.Info(fmt.Sprintf("Deployment %s would be scaled to 0", deployment.Name))
+									plan.touch(planDeployment, deployment.Name)
 								}
 							}
 						}
@@ -3,6 +3,7 @@ package idler
 import (
 	"context"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -14,12 +15,20 @@ import (
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=list;get;watch;patch
 
 // CLIIdler will run the CLI idler process.
-func (h *Idler) CLIIdler() {
-	ctx := context.Background()
+func (h *Idler) CLIIdler(ctx context.Context) {
+	h.runIdler(ctx, CLIIdlerName, h.Log.WithName("aergia-controller").WithName("CLIIdler"), h.cliIdler)
+}
+
+func (h *Idler) cliIdler(ctx context.Context) (int, error) {
 	opLog := h.Log.WithName("aergia-controller").WithName("CLIIdler")
+	selectors := h.GetSelectors()
 	// in kubernetes, we can reliably check for the existence of this label so that
 	// we only check namespaces that have been deployed by a lagoon at one point
-	labelRequirements := generateLabelRequirements(h.Selectors.CLI.Namespace)
+	labelRequirements, err := generateLabelRequirements(selectors.CLI.Namespace)
+	if err != nil {
+		opLog.Error(err, "unable to generate namespace selectors")
+		return 0, err
+	}
 	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 		client.MatchingLabelsSelector{
 			Selector: labels.NewSelector().Add(labelRequirements...),
@@ -28,18 +37,23 @@ func (h *Idler) CLIIdler() {
 	namespaces := &corev1.NamespaceList{}
 	if err := h.Client.List(ctx, namespaces, listOption); err != nil {
 		opLog.Error(err, "unable to get any namespaces")
-		return
+		return 0, err
 	}
-	for _, namespace := range namespaces.Items {
-		projectAutoIdle, ok1 := namespace.Labels[h.Selectors.NamespaceSelectorsLabels.ProjectIdling]
-		environmentAutoIdle, ok2 := namespace.Labels[h.Selectors.NamespaceSelectorsLabels.EnvironmentIdling]
+	return h.forEachNamespace(ctx, CLIIdlerName, opLog, namespaces.Items, func(ctx context.Context, namespace corev1.Namespace) {
+		projectAutoIdle, ok1 := namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectIdling]
+		environmentAutoIdle, ok2 := namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentIdling]
 		if ok1 && ok2 {
 			if environmentAutoIdle == "1" && projectAutoIdle == "1" {
 				envOpLog := opLog.WithValues("namespace", namespace.Name).
-					WithValues("project", namespace.Labels[h.Selectors.NamespaceSelectorsLabels.ProjectName]).
-					WithValues("environment", namespace.Labels[h.Selectors.NamespaceSelectorsLabels.EnvironmentName]).
+					WithValues("project", namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName]).
+					WithValues("environment", namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentName]).
 					WithValues("dry-run", h.DryRun)
 				envOpLog.Info("Checking namespace")
+				if freeze, ok := h.frozen(namespace, time.Now()); ok {
+					envOpLog.Info(fmt.Sprintf("CLI not idled, %s", freeze))
+					planFor(ctx).decide(DecisionSkip, PlanReasonFrozen, "%s", freeze)
+					return
+				}
 				h.kubernetesCLI(ctx, envOpLog, namespace)
 			} else if h.Debug {
 				opLog.Info(fmt.Sprintf("skipping namespace %s; autoidle values are env:%s proj:%s",
@@ -53,5 +67,5 @@ func (h *Idler) CLIIdler() {
 					namespace.Name))
 			}
 		}
-	}
+	}), nil
 }
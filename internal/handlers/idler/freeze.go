@@ -0,0 +1,109 @@
+package idler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Freeze stops the service and cli idlers from idling the namespaces it selects between its start and end.
+type Freeze struct {
+	Name     string
+	Reason   string
+	Start    time.Time
+	End      time.Time
+	Selector labels.Selector
+}
+
+// NewFreeze converts an IdlingFreeze into the freeze used by the idler.
+func NewFreeze(freeze idlingv1alpha1.IdlingFreeze) (Freeze, error) {
+	f := Freeze{
+		Name:     freeze.Name,
+		Reason:   freeze.Spec.Reason,
+		Selector: labels.Everything(),
+	}
+	if freeze.Spec.Start != nil {
+		f.Start = freeze.Spec.Start.Time
+	}
+	if freeze.Spec.End != nil {
+		f.End = freeze.Spec.End.Time
+	}
+	if !f.Start.IsZero() && !f.End.IsZero() && !f.End.After(f.Start) {
+		return f, fmt.Errorf("end %s is not after start %s", f.End.Format(time.RFC3339), f.Start.Format(time.RFC3339))
+	}
+	if freeze.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(freeze.Spec.NamespaceSelector)
+		if err != nil {
+			return f, fmt.Errorf("invalid namespace selector: %v", err)
+		}
+		f.Selector = selector
+	}
+	return f, nil
+}
+
+// Active returns true if the freeze has started and not ended.
+func (f Freeze) Active(now time.Time) bool {
+	return !now.Before(f.Start) && (f.End.IsZero() || now.Before(f.End))
+}
+
+// Next returns when the freeze next starts or ends after now, or zero if it won't change again.
+func (f Freeze) Next(now time.Time) time.Time {
+	if now.Before(f.Start) {
+		return f.Start
+	}
+	if now.Before(f.End) {
+		return f.End
+	}
+	return time.Time{}
+}
+
+// String describes the freeze for the logs and plans.
+func (f Freeze) String() string {
+	s := fmt.Sprintf("idling is frozen by %s", f.Name)
+	if !f.End.IsZero() {
+		s = fmt.Sprintf("%s until %s", s, f.End.UTC().Format(time.RFC3339))
+	}
+	if f.Reason != "" {
+		s = fmt.Sprintf("%s: %s", s, f.Reason)
+	}
+	return s
+}
+
+// SetFreeze adds or replaces a freeze.
+func (h *Idler) SetFreeze(freeze Freeze) {
+	h.freezesLock.Lock()
+	defer h.freezesLock.Unlock()
+	if h.freezes == nil {
+		h.freezes = map[string]Freeze{}
+	}
+	h.freezes[freeze.Name] = freeze
+}
+
+// RemoveFreeze removes a freeze, the namespaces it selected can be idled again.
+func (h *Idler) RemoveFreeze(name string) {
+	h.freezesLock.Lock()
+	defer h.freezesLock.Unlock()
+	delete(h.freezes, name)
+}
+
+// frozen returns the active freeze that selects the namespace, if there is more than one the first by name is used.
+func (h *Idler) frozen(namespace corev1.Namespace, now time.Time) (Freeze, bool) {
+	h.freezesLock.RLock()
+	defer h.freezesLock.RUnlock()
+	names := []string{}
+	for name, freeze := range h.freezes {
+		if freeze.Active(now) && freeze.Selector.Matches(labels.Set(namespace.Labels)) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return Freeze{}, false
+	}
+	sort.Strings(names)
+	return h.freezes[names[0]], true
+}
@@ -0,0 +1,241 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewFreeze(t *testing.T) {
+	start := time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		spec    idlingv1alpha1.IdlingFreezeSpec
+		wantErr bool
+	}{
+		{
+			name: "test1",
+			spec: idlingv1alpha1.IdlingFreezeSpec{},
+		},
+		{
+			name: "test2",
+			spec: idlingv1alpha1.IdlingFreezeSpec{
+				Start: &metav1.Time{Time: start},
+				End:   &metav1.Time{Time: start.Add(time.Hour)},
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"lagoon.sh/environmentType": "production"},
+				},
+			},
+		},
+		{
+			name: "test3",
+			spec: idlingv1alpha1.IdlingFreezeSpec{
+				Start: &metav1.Time{Time: start},
+				End:   &metav1.Time{Time: start},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test4",
+			spec: idlingv1alpha1.IdlingFreezeSpec{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "lagoon.sh/environmentType", Operator: "Sometimes"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFreeze(idlingv1alpha1.IdlingFreeze{
+				ObjectMeta: metav1.ObjectMeta{Name: "release"},
+				Spec:       tt.spec,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFreeze() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIdler_frozen(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	namespace := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "example-com-main",
+			Labels: map[string]string{"lagoon.sh/environmentType": "development"},
+		},
+	}
+	tests := []struct {
+		name     string
+		freezes  []idlingv1alpha1.IdlingFreeze
+		wantName string
+		wantNext time.Time
+	}{
+		{
+			name: "test1",
+		},
+		{
+			name: "test2",
+			freezes: []idlingv1alpha1.IdlingFreeze{
+				{ObjectMeta: metav1.ObjectMeta{Name: "incident"}},
+			},
+			wantName: "incident",
+		},
+		{
+			name: "test3",
+			freezes: []idlingv1alpha1.IdlingFreeze{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "release"},
+					Spec: idlingv1alpha1.IdlingFreezeSpec{
+						Start: &metav1.Time{Time: now.Add(-time.Hour)},
+						End:   &metav1.Time{Time: now.Add(time.Hour)},
+					},
+				},
+			},
+			wantName: "release",
+			wantNext: now.Add(time.Hour),
+		},
+		{
+			name: "test4",
+			freezes: []idlingv1alpha1.IdlingFreeze{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "release"},
+					Spec: idlingv1alpha1.IdlingFreezeSpec{
+						Start: &metav1.Time{Time: now.Add(time.Hour)},
+					},
+				},
+			},
+			wantNext: now.Add(time.Hour),
+		},
+		{
+			name: "test5",
+			freezes: []idlingv1alpha1.IdlingFreeze{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "release"},
+					Spec: idlingv1alpha1.IdlingFreezeSpec{
+						End: &metav1.Time{Time: now},
+					},
+				},
+			},
+		},
+		{
+			name: "test6",
+			freezes: []idlingv1alpha1.IdlingFreeze{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "demo"},
+					Spec: idlingv1alpha1.IdlingFreezeSpec{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"lagoon.sh/environmentType": "production"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "test7",
+			freezes: []idlingv1alpha1.IdlingFreeze{
+				{ObjectMeta: metav1.ObjectMeta{Name: "release"}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "incident"}},
+			},
+			wantName: "incident",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Idler{}
+			var next time.Time
+			for _, freeze := range tt.freezes {
+				f, err := NewFreeze(freeze)
+				if err != nil {
+					t.Fatal(err)
+				}
+				h.SetFreeze(f)
+				next = f.Next(now)
+			}
+			freeze, ok := h.frozen(namespace, now)
+			if ok != (tt.wantName != "") || freeze.Name != tt.wantName {
+				t.Errorf("frozen() = %s, %v, want %s", freeze.Name, ok, tt.wantName)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("Next() = %s, want %s", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestServiceIdlerFreeze(t *testing.T) {
+	tests := []struct {
+		name         string
+		freeze       *idlingv1alpha1.IdlingFreeze
+		wantDecision string
+		wantReason   string
+		wantMessage  string
+		wantReplicas int32
+	}{
+		{
+			name:         "test1",
+			wantDecision: DecisionIdle,
+			wantReason:   PlanReasonNoHits,
+			wantMessage:  "pods have been running for more than 4h0m0s and it has had 0 hits in the last 4h0m0s",
+			wantReplicas: 0,
+		},
+		{
+			name: "test2",
+			freeze: &idlingv1alpha1.IdlingFreeze{
+				ObjectMeta: metav1.ObjectMeta{Name: "release"},
+				Spec: idlingv1alpha1.IdlingFreezeSpec{
+					End:    &metav1.Time{Time: time.Date(2099, 1, 2, 18, 0, 0, 0, time.UTC)},
+					Reason: "release day",
+				},
+			},
+			wantDecision: DecisionSkip,
+			wantReason:   PlanReasonFrozen,
+			wantMessage:  "idling is frozen by release until 2099-01-02T18:00:00Z: release day",
+			wantReplicas: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectors := readTestSelectors(t, "testdata/valid-selectors.yaml")
+			h, namespace := newTestIdler(t, selectors, &StaticHitSource{})
+			namespace.Labels["lagoon.sh/project"] = "example-com"
+			namespace.Labels["lagoon.sh/projectAutoIdle"] = "1"
+			namespace.Labels["lagoon.sh/environmentAutoIdle"] = "1"
+			if err := h.Client.Update(context.Background(), &namespace); err != nil {
+				t.Fatal(err)
+			}
+			if tt.freeze != nil {
+				f, err := NewFreeze(*tt.freeze)
+				if err != nil {
+					t.Fatal(err)
+				}
+				h.SetFreeze(f)
+			}
+			h.ServiceIdler(context.Background())
+			plan := h.Plan(ServiceIdlerName)
+			if plan == nil || len(plan.Namespaces) != 1 {
+				t.Fatalf("Plan() = %+v, want a plan with one namespace", plan)
+			}
+			got := plan.Namespaces[0]
+			if got.Decision != tt.wantDecision || got.Reason != tt.wantReason || got.Message != tt.wantMessage {
+				t.Errorf("plan = %s, %s, %q, want %s, %s, %q", got.Decision, got.Reason, got.Message, tt.wantDecision, tt.wantReason, tt.wantMessage)
+			}
+			deployment := &appsv1.Deployment{}
+			if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.Name, Name: "nginx"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != tt.wantReplicas {
+				t.Errorf("deployment replicas = %d, want %d", *deployment.Spec.Replicas, tt.wantReplicas)
+			}
+		})
+	}
+}
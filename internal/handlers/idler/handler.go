@@ -5,17 +5,19 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
-	prometheusapi "github.com/prometheus/client_golang/api"
+	"github.com/uselagoon/aergia-controller/internal/handlers/notifier"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/events"
 	"k8s.io/client-go/tools/remotecommand"
 	client "sigs.k8s.io/controller-runtime/pkg/client"
-	config "sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 // for pod exec and log collection
@@ -25,14 +27,28 @@ import (
 // Idler handles idling of cli and services.
 type Idler struct {
 	Client                  client.Client
+	RestConfig              *rest.Config
 	PodCheckInterval        time.Duration
 	Log                     logr.Logger
 	Scheme                  *runtime.Scheme
 	DryRun                  bool
 	Debug                   bool
 	Selectors               *Data
-	PrometheusClient        prometheusapi.Client
+	HitSource               HitSource
 	PrometheusCheckInterval time.Duration
+	HTTPRouteBackend        HTTPRouteBackend
+	Recorder                events.EventRecorder
+	Notifier                *notifier.Notifier
+	Concurrency             int
+	NamespaceTimeout        time.Duration
+	PlanDir                 string
+	MinAwake                time.Duration
+	policySelectors         *Data
+	selectorsLock           sync.RWMutex
+	freezes                 map[string]Freeze
+	freezesLock             sync.RWMutex
+	runs                    sync.Map
+	plans                   sync.Map
 }
 
 type idlerSelector struct {
@@ -71,25 +87,58 @@ type CLI struct {
 
 // Service .
 type Service struct {
-	SkipBuildCheck   bool            `json:"skipbuildcheck"`
-	SkipHitCheck     bool            `json:"skipcroncheck"`
-	SkipIngressPatch bool            `json:"skipingresspatch"`
-	Namespace        []idlerSelector `json:"namespace"`
-	Builds           []idlerSelector `json:"builds"`
-	Deployments      []idlerSelector `json:"deployments"`
-	Pods             []idlerSelector `json:"pods"`
-	Ingress          []idlerSelector `json:"ingress"`
+	SkipBuildCheck          bool            `json:"skipbuildcheck"`
+	SkipHitCheck            bool            `json:"skipcroncheck"`
+	SkipIngressPatch        bool            `json:"skipingresspatch"`
+	PodCheckInterval        time.Duration   `json:"podcheckinterval,omitempty"`
+	PrometheusCheckInterval time.Duration   `json:"prometheuscheckinterval,omitempty"`
+	Namespace               []idlerSelector `json:"namespace"`
+	Builds                  []idlerSelector `json:"builds"`
+	Deployments             []idlerSelector `json:"deployments"`
+	Pods                    []idlerSelector `json:"pods"`
+	Ingress                 []idlerSelector `json:"ingress"`
+	StatefulSets            []idlerSelector `json:"statefulsets,omitempty"`
+	CronJobs                []idlerSelector `json:"cronjobs,omitempty"`
+	HTTPRoutes              []idlerSelector `json:"httproutes,omitempty"`
+	HitQueries              []HitQuery      `json:"hitqueries,omitempty"`
+	HitStatusRegex          string          `json:"hitstatusregex,omitempty"`
 }
 
-func execPod(
+// GetSelectors returns the selectors from the active IdlingPolicy, or the selectors file if there is no policy.
+func (h *Idler) GetSelectors() *Data {
+	h.selectorsLock.RLock()
+	defer h.selectorsLock.RUnlock()
+	if h.policySelectors != nil {
+		return h.policySelectors
+	}
+	return h.Selectors
+}
+
+// SetSelectors replaces the selectors from the selectors file, an active IdlingPolicy still takes precedence.
+func (h *Idler) SetSelectors(selectors *Data) {
+	h.selectorsLock.Lock()
+	defer h.selectorsLock.Unlock()
+	h.Selectors = selectors
+}
+
+// SetPolicySelectors replaces the selectors provided by an IdlingPolicy, nil reverts to the selectors file.
+func (h *Idler) SetPolicySelectors(selectors *Data) {
+	h.selectorsLock.Lock()
+	defer h.selectorsLock.Unlock()
+	h.policySelectors = selectors
+}
+
+// execPod runs a command in a pod, using the rest config of the idler so that it is the same cluster as its client.
+func (h *Idler) execPod(
+	ctx context.Context,
 	podName, namespace string,
 	command []string,
 	stdin io.Reader,
 	tty bool,
 ) (string, string, error) {
-	restCfg, err := config.GetConfig()
-	if err != nil {
-		return "", "", fmt.Errorf("unable to get config: %v", err)
+	restCfg := h.RestConfig
+	if restCfg == nil {
+		return "", "", fmt.Errorf("unable to exec in pod %s, the idler has no rest config", podName)
 	}
 	clientset, err := kubernetes.NewForConfig(restCfg)
 	if err != nil {
@@ -122,7 +171,7 @@ func execPod(
 	}
 
 	var stdout, stderr bytes.Buffer
-	err = exec.StreamWithContext(context.Background(),
+	err = exec.StreamWithContext(ctx,
 		remotecommand.StreamOptions{
 			Stdin:  stdin,
 			Stdout: &stdout,
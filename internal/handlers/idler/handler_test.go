@@ -144,6 +144,23 @@ func TestSelectorsYaml(t *testing.T) {
 							Operator: selection.Operator("exists"),
 						},
 					},
+					StatefulSets: []idlerSelector{
+						{
+							Name:     "lagoon.sh/jobType",
+							Operator: selection.Operator("notin"),
+							Values:   []string{"build"},
+						},
+						{
+							Name:     "lagoon.sh/environment",
+							Operator: selection.Operator("exists"),
+						},
+					},
+					CronJobs: []idlerSelector{
+						{
+							Name:     "lagoon.sh/environment",
+							Operator: selection.Operator("exists"),
+						},
+					},
 				},
 			},
 		},
@@ -166,3 +183,44 @@ func TestSelectorsYaml(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectorsValidate(t *testing.T) {
+	var testCases = map[string]struct {
+		input       string
+		description string
+		wantErr     bool
+	}{
+		"valid-yaml": {
+			input:       "testdata/valid-selectors.yaml",
+			description: "This test checks that valid selectors pass validation",
+		},
+		"invalid-yaml": {
+			input:       "testdata/invalid-selectors.yaml",
+			description: "This test checks that an unknown operator or missing values fail validation",
+			wantErr:     true,
+		},
+		"invalid-hitqueries": {
+			input:       "testdata/invalid-hitqueries.yaml",
+			description: "This test checks that an invalid hit query template or status regex fail validation",
+			wantErr:     true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(tt *testing.T) {
+			file, err := os.Open(tc.input)
+			if err != nil {
+				tt.Fatal(err)
+			}
+			defer file.Close()
+			d := yaml.NewDecoder(file)
+			selectors := &Data{}
+			if err := d.Decode(&selectors); err != nil {
+				tt.Fatal(err)
+			}
+			err = selectors.Validate()
+			if (err != nil) != tc.wantErr {
+				tt.Fatalf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -1,23 +1,80 @@
 package idler
 
 import (
+	"fmt"
+	"os"
 	"strings"
 
+	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/labels"
 )
 
-func generateSelector(s idlerSelector) *labels.Requirement {
-	r, _ := labels.NewRequirement(s.Name, s.Operator, s.Values)
-	return r
+// ReadSelectors reads the selectors from a yaml file and validates them.
+func ReadSelectors(path string) (*Data, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	selectors := &Data{}
+	if err := yaml.NewDecoder(file).Decode(&selectors); err != nil {
+		return nil, err
+	}
+	if err := selectors.Validate(); err != nil {
+		return nil, err
+	}
+	return selectors, nil
+}
+
+func generateSelector(s idlerSelector) (*labels.Requirement, error) {
+	r, err := labels.NewRequirement(s.Name, s.Operator, s.Values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %s %s %v: %v", s.Name, s.Operator, s.Values, err)
+	}
+	return r, nil
 }
 
-func generateLabelRequirements(selectors []idlerSelector) []labels.Requirement {
+func generateLabelRequirements(selectors []idlerSelector) ([]labels.Requirement, error) {
 	labelRequirements := []labels.Requirement{}
 	for _, rs := range selectors {
-		selector := generateSelector(rs)
+		selector, err := generateSelector(rs)
+		if err != nil {
+			return nil, err
+		}
 		labelRequirements = append(labelRequirements, *selector)
 	}
-	return labelRequirements
+	return labelRequirements, nil
+}
+
+// Validate checks that all the selectors can be converted into label requirements, and the hit queries are valid.
+func (d *Data) Validate() error {
+	errs := []string{}
+	for _, s := range []struct {
+		field     string
+		selectors []idlerSelector
+	}{
+		{"cli.namespace", d.CLI.Namespace},
+		{"cli.builds", d.CLI.Builds},
+		{"cli.deployments", d.CLI.Deployments},
+		{"cli.pods", d.CLI.Pods},
+		{"service.namespace", d.Service.Namespace},
+		{"service.builds", d.Service.Builds},
+		{"service.deployments", d.Service.Deployments},
+		{"service.pods", d.Service.Pods},
+		{"service.ingress", d.Service.Ingress},
+		{"service.statefulsets", d.Service.StatefulSets},
+		{"service.cronjobs", d.Service.CronJobs},
+		{"service.httproutes", d.Service.HTTPRoutes},
+	} {
+		if _, err := generateLabelRequirements(s.selectors); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", s.field, err))
+		}
+	}
+	errs = append(errs, d.validateHitQueries()...)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 func addStatusCode(codes string, code string) *string {
@@ -33,3 +90,12 @@ func addStatusCode(codes string, code string) *string {
 	newCodes := codes + "," + code
 	return &newCodes
 }
+
+// NamespaceSelector returns the label selector for the namespaces the service idler checks.
+func (h *Idler) NamespaceSelector() (labels.Selector, error) {
+	labelRequirements, err := generateLabelRequirements(h.GetSelectors().Service.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return labels.NewSelector().Add(labelRequirements...), nil
+}
@@ -0,0 +1,136 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	prometheusapi "github.com/prometheus/client_golang/api"
+	prometheusapiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	prometheusmodel "github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// HitSource returns the number of hits a namespace has received over an interval.
+type HitSource interface {
+	Hits(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, interval time.Duration) (int, error)
+}
+
+// CombineMode defines how the hits from multiple sources are combined.
+type CombineMode string
+
+const (
+	// CombineSum adds the hits from all sources together.
+	CombineSum CombineMode = "sum"
+	// CombineMax uses the highest number of hits from any source.
+	CombineMax CombineMode = "max"
+)
+
+// PrometheusHitSource queries prometheus for the number of requests to the ingress controllers.
+// The queries are read from the selectors, if there are none the default queries are used.
+// If there is a kube client, the services in the namespace are listed so the queries can match them by name.
+type PrometheusHitSource struct {
+	Client     prometheusapi.Client
+	KubeClient client.Client
+	Timeout    time.Duration
+	Selectors  func() *Data
+}
+
+// Hits returns the number of successful requests made to any ingress in the namespace.
+func (p *PrometheusHitSource) Hits(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, interval time.Duration) (int, error) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	var selectors *Data
+	if p.Selectors != nil {
+		selectors = p.Selectors()
+	}
+	queries, statusRegex := selectors.hitQueries()
+	vars := newHitQueryVars(selectors, namespace, interval, statusRegex)
+	if p.KubeClient != nil {
+		services := &corev1.ServiceList{}
+		if err := p.KubeClient.List(ctx, services, client.InNamespace(namespace.Name)); err != nil {
+			return 0, fmt.Errorf("error listing services in %s: %v", namespace.Name, err)
+		}
+		vars.Services = serviceNamesRegex(services.Items)
+		vars.ServicePorts = servicePortsRegex(services.Items)
+	}
+	v1api := prometheusapiv1.NewAPI(p.Client)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	numHits := 0
+	for _, q := range queries {
+		promQuery, err := renderHitQuery(q, vars)
+		if err != nil {
+			return 0, fmt.Errorf("error rendering hit query %s: %v", q.Name, err)
+		}
+		result, warnings, err := v1api.Query(ctx, promQuery, time.Now())
+		if err != nil {
+			return 0, fmt.Errorf("error querying prometheus with hit query %s: %v", q.Name, err)
+		}
+		if len(warnings) > 0 {
+			opLog.Info(fmt.Sprintf("Warnings: %v", warnings))
+		}
+		// and then add up the results of all the status requests to determine hit count
+		queryHits := 0
+		if result.Type() == prometheusmodel.ValVector {
+			resultVal := result.(prometheusmodel.Vector)
+			for _, elem := range resultVal {
+				hits, _ := strconv.Atoi(elem.Value.String())
+				queryHits += hits
+			}
+		}
+		opLog.Info(fmt.Sprintf("Hit query %s returned %d hits", q.Name, queryHits))
+		numHits += queryHits
+	}
+	return numHits, nil
+}
+
+// StaticHitSource returns a fixed number of hits per namespace, it is intended for use in tests.
+type StaticHitSource struct {
+	NamespaceHits map[string]int
+	DefaultHits   int
+	Err           error
+}
+
+// Hits returns the hits defined for the namespace, or the default hits.
+func (s *StaticHitSource) Hits(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, interval time.Duration) (int, error) {
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	if hits, ok := s.NamespaceHits[namespace.Name]; ok {
+		return hits, nil
+	}
+	return s.DefaultHits, nil
+}
+
+// CombinedHitSource combines the hits from multiple sources. If any source returns an error, the error is returned
+// so that an environment is never idled based on partial results.
+type CombinedHitSource struct {
+	Sources []HitSource
+	Mode    CombineMode
+}
+
+// Hits returns the combined hits from all sources.
+func (c *CombinedHitSource) Hits(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, interval time.Duration) (int, error) {
+	numHits := 0
+	for _, source := range c.Sources {
+		hits, err := source.Hits(ctx, opLog, namespace, interval)
+		if err != nil {
+			return 0, err
+		}
+		switch c.Mode {
+		case CombineMax:
+			if hits > numHits {
+				numHits = hits
+			}
+		default:
+			numHits += hits
+		}
+	}
+	return numHits, nil
+}
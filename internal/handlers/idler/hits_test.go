@@ -0,0 +1,242 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/notifier"
+	"gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCombinedHitSource(t *testing.T) {
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}}
+	tests := []struct {
+		name    string
+		source  HitSource
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "test1",
+			source: &CombinedHitSource{
+				Sources: []HitSource{
+					&StaticHitSource{NamespaceHits: map[string]int{"example-com-main": 3}},
+					&StaticHitSource{DefaultHits: 4},
+				},
+			},
+			want: 7,
+		},
+		{
+			name: "test2",
+			source: &CombinedHitSource{
+				Mode: CombineMax,
+				Sources: []HitSource{
+					&StaticHitSource{NamespaceHits: map[string]int{"example-com-main": 3}},
+					&StaticHitSource{DefaultHits: 4},
+				},
+			},
+			want: 4,
+		},
+		{
+			name: "test3",
+			source: &CombinedHitSource{
+				Sources: []HitSource{
+					&StaticHitSource{DefaultHits: 4},
+					&StaticHitSource{Err: fmt.Errorf("unavailable")},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.Hits(context.Background(), logr.Discard(), ns, time.Hour)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Hits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Hits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesServiceIdlerHits(t *testing.T) {
+	tests := []struct {
+		name      string
+		hitSource HitSource
+		wantIdled bool
+		wantEvent string
+	}{
+		{
+			name:      "no-hits",
+			hitSource: &StaticHitSource{},
+			wantIdled: true,
+			wantEvent: "Normal Idled Environment idled, pods have been running for more than 4h0m0s and it has had 0 hits in the last 4h0m0s",
+		},
+		{
+			name:      "hits",
+			hitSource: &StaticHitSource{DefaultHits: 12},
+			wantIdled: false,
+			wantEvent: "Normal SkippedHits Environment not idled, it has had 12 hits in the last 4h0m0s",
+		},
+		{
+			name:      "hit-source-error",
+			hitSource: &StaticHitSource{Err: fmt.Errorf("unavailable")},
+			wantIdled: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectors := readTestSelectors(t, "testdata/valid-selectors.yaml")
+			h, namespace := newTestIdler(t, selectors, tt.hitSource)
+			idled := h.KubernetesServiceIdler(context.Background(), logr.Discard(), namespace, "example-com", false, false)
+			if idled != tt.wantIdled {
+				t.Fatalf("KubernetesServiceIdler() = %v, want %v", idled, tt.wantIdled)
+			}
+			deployment := &appsv1.Deployment{}
+			if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.Name, Name: "nginx"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			if (*deployment.Spec.Replicas == 0) != tt.wantIdled {
+				t.Errorf("deployment replicas = %d, want idled %v", *deployment.Spec.Replicas, tt.wantIdled)
+			}
+			event := ""
+			select {
+			case event = <-h.Recorder.(*events.FakeRecorder).Events:
+			default:
+			}
+			if event != tt.wantEvent {
+				t.Errorf("event = %q, want %q", event, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestKubernetesServiceIdlerNotifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		forceIdle  bool
+		forceScale bool
+		wantEvent  string
+	}{
+		{
+			name:      "test1",
+			wantEvent: "idled",
+		},
+		{
+			name:      "test2",
+			forceIdle: true,
+			wantEvent: "force-idled",
+		},
+		{
+			name:       "test3",
+			forceScale: true,
+			wantEvent:  "force-scaled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r.Header.Get(notifier.EventHeader)
+			}))
+			defer server.Close()
+			selectors := readTestSelectors(t, "testdata/valid-selectors.yaml")
+			h, namespace := newTestIdler(t, selectors, &StaticHitSource{})
+			h.Notifier = &notifier.Notifier{Endpoints: []notifier.Endpoint{{Name: "test", URL: server.URL}}}
+			if !h.KubernetesServiceIdler(context.Background(), logr.Discard(), namespace, "example-com", tt.forceIdle, tt.forceScale) {
+				t.Fatalf("KubernetesServiceIdler() = false, want true")
+			}
+			h.Notifier.Wait()
+			select {
+			case event := <-received:
+				if event != tt.wantEvent {
+					t.Errorf("notifier event = %s, want %s", event, tt.wantEvent)
+				}
+			default:
+				t.Errorf("notifier event not sent, want %s", tt.wantEvent)
+			}
+		})
+	}
+}
+
+func readTestSelectors(t *testing.T, path string) *Data {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	selectors := &Data{}
+	if err := yaml.NewDecoder(file).Decode(&selectors); err != nil {
+		t.Fatal(err)
+	}
+	return selectors
+}
+
+// newTestIdler returns an idler with a fake client containing an environment that has been running for a day.
+func newTestIdler(t *testing.T, selectors *Data, hitSource HitSource) (*Idler, corev1.Namespace) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespace := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "example-com-main",
+			Labels: map[string]string{
+				"lagoon.sh/environmentType": "development",
+			},
+		},
+	}
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nginx",
+			Namespace: namespace.Name,
+			Labels: map[string]string{
+				"lagoon.sh/service":     "nginx",
+				"lagoon.sh/environment": "main",
+			},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nginx-abcde",
+			Namespace: namespace.Name,
+			Labels: map[string]string{
+				"lagoon.sh/service": "nginx",
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:     corev1.PodRunning,
+			StartTime: &metav1.Time{Time: time.Now().Add(-24 * time.Hour)},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace.DeepCopy(), deployment, pod).Build()
+	return &Idler{
+		Client:                  c,
+		Log:                     logr.Discard(),
+		PodCheckInterval:        4 * time.Hour,
+		PrometheusCheckInterval: 4 * time.Hour,
+		HitSource:               hitSource,
+		Selectors:               selectors,
+		Recorder:                events.NewFakeRecorder(10),
+	}, namespace
+}
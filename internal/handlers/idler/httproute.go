@@ -0,0 +1,151 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;update;patch
+
+const (
+	// UnidleRulesAnnotation stores the original rules of an idled HTTPRoute so they can be restored by the unidler.
+	UnidleRulesAnnotation = "idling.amazee.io/unidle-rules"
+	// HTTPRouteNameHeader is the header added to requests redirected to the backend by an idled HTTPRoute.
+	HTTPRouteNameHeader = "X-HTTPRoute-Name"
+)
+
+// HTTPRouteBackend is the service that the rules of idled HTTPRoutes are redirected to.
+type HTTPRouteBackend struct {
+	Namespace string
+	Name      string
+	Port      int32
+}
+
+// ParseHTTPRouteBackend parses a backend in the format `namespace/name:port`.
+func ParseHTTPRouteBackend(value string) (HTTPRouteBackend, error) {
+	namespace, service, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" {
+		return HTTPRouteBackend{}, fmt.Errorf("backend %s must be in the format namespace/name:port", value)
+	}
+	name, port, ok := strings.Cut(service, ":")
+	if !ok || name == "" {
+		return HTTPRouteBackend{}, fmt.Errorf("backend %s must be in the format namespace/name:port", value)
+	}
+	portNumber, err := strconv.ParseInt(port, 10, 32)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return HTTPRouteBackend{}, fmt.Errorf("backend %s has an invalid port", value)
+	}
+	return HTTPRouteBackend{Namespace: namespace, Name: name, Port: int32(portNumber)}, nil
+}
+
+// idledRules returns the rules of an HTTPRoute with the backends replaced by the aergia backend, and a filter that adds the
+// headers the unidler needs to identify the namespace and route.
+func (b HTTPRouteBackend) idledRules(route gatewayv1.HTTPRoute) []gatewayv1.HTTPRouteRule {
+	namespace := gatewayv1.Namespace(b.Namespace)
+	port := gatewayv1.PortNumber(b.Port)
+	rules := []gatewayv1.HTTPRouteRule{}
+	for _, rule := range route.Spec.Rules {
+		rules = append(rules, gatewayv1.HTTPRouteRule{
+			Name:    rule.Name,
+			Matches: rule.Matches,
+			Filters: []gatewayv1.HTTPRouteFilter{
+				{
+					Type: gatewayv1.HTTPRouteFilterRequestHeaderModifier,
+					RequestHeaderModifier: &gatewayv1.HTTPHeaderFilter{
+						Set: []gatewayv1.HTTPHeader{
+							{Name: "X-Namespace", Value: route.Namespace},
+							{Name: HTTPRouteNameHeader, Value: route.Name},
+							{Name: "X-Code", Value: "503"},
+						},
+					},
+				},
+			},
+			BackendRefs: []gatewayv1.HTTPBackendRef{
+				{
+					BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{
+							Name:      gatewayv1.ObjectName(b.Name),
+							Namespace: &namespace,
+							Port:      &port,
+						},
+					},
+				},
+			},
+		})
+	}
+	return rules
+}
+
+/*
+patchHTTPRoutes will redirect the rules of any HTTPRoute with matching labels to the aergia backend, the original rules are
+stored in the `idling.amazee.io/unidle-rules` annotation so that the unidler can restore them.
+*/
+func (h *Idler) patchHTTPRoutes(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, selectors *Data) (bool, error) {
+	if h.HTTPRouteBackend.Name == "" || len(selectors.Service.HTTPRoutes) == 0 {
+		return false, nil
+	}
+	labelRequirements, err := generateLabelRequirements(selectors.Service.HTTPRoutes)
+	if err != nil {
+		opLog.Error(err, "Error generating httproute selectors")
+		return false, fmt.Errorf("error generating httproute selectors")
+	}
+	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
+		client.InNamespace(namespace.Name),
+		client.MatchingLabelsSelector{
+			Selector: labels.NewSelector().Add(labelRequirements...),
+		},
+	})
+	routes := &gatewayv1.HTTPRouteList{}
+	if err := h.Client.List(ctx, routes, listOption); err != nil {
+		opLog.Error(err, "Error getting httproutes")
+		return false, fmt.Errorf("error getting httproutes")
+	}
+	patched := false
+	for _, route := range routes.Items {
+		if _, ok := route.Annotations[UnidleRulesAnnotation]; ok {
+			// the route is already idled, patching it again would overwrite the original rules
+			continue
+		}
+		if h.DryRun {
+			opLog.Info(fmt.Sprintf("HTTPRoute %s would be patched", route.Name))
+			planFor(ctx).touch(planHTTPRoute, route.Name)
+			continue
+		}
+		originalRules, err := json.Marshal(route.Spec.Rules)
+		if err != nil {
+			return false, fmt.Errorf("error encoding rules of httproute %s", route.Name)
+		}
+		mergePatch, _ := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{
+				"rules": h.HTTPRouteBackend.idledRules(route),
+			},
+			"metadata": map[string]interface{}{
+				"labels": map[string]string{
+					"idling.amazee.io/idled": "true",
+				},
+				"annotations": map[string]string{
+					UnidleRulesAnnotation: string(originalRules),
+				},
+			},
+		})
+		routeCopy := route.DeepCopy()
+		if err := h.Client.Patch(ctx, routeCopy, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+			opLog.Info(fmt.Sprintf("Error patching httproute %s", route.Name))
+			return false, fmt.Errorf("error patching httproute %s", route.Name)
+		}
+		opLog.Info(fmt.Sprintf("HTTPRoute %s patched", route.Name))
+		planFor(ctx).touch(planHTTPRoute, route.Name)
+		patched = true
+	}
+	return patched, nil
+}
@@ -0,0 +1,125 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestParseHTTPRouteBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    HTTPRouteBackend
+		wantErr bool
+	}{
+		{
+			name:  "test1",
+			value: "aergia/aergia-backend:80",
+			want:  HTTPRouteBackend{Namespace: "aergia", Name: "aergia-backend", Port: 80},
+		},
+		{
+			name:    "test2",
+			value:   "aergia-backend:80",
+			wantErr: true,
+		},
+		{
+			name:    "test3",
+			value:   "aergia/aergia-backend:http",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHTTPRouteBackend(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHTTPRouteBackend() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseHTTPRouteBackend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchHTTPRoutes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatewayv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}}
+	port := gatewayv1.PortNumber(8080)
+	rules := []gatewayv1.HTTPRouteRule{
+		{
+			BackendRefs: []gatewayv1.HTTPBackendRef{
+				{
+					BackendRef: gatewayv1.BackendRef{
+						BackendObjectReference: gatewayv1.BackendObjectReference{
+							Name: "nginx",
+							Port: &port,
+						},
+					},
+				},
+			},
+		},
+	}
+	route := &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: namespace.Name,
+			Labels: map[string]string{
+				"lagoon.sh/autogenerated": "true",
+			},
+		},
+		Spec: gatewayv1.HTTPRouteSpec{
+			Hostnames: []gatewayv1.Hostname{"example.com"},
+			Rules:     rules,
+		},
+	}
+	h := &Idler{
+		Client:           fake.NewClientBuilder().WithScheme(scheme).WithObjects(route).Build(),
+		HTTPRouteBackend: HTTPRouteBackend{Namespace: "aergia", Name: "aergia-backend", Port: 80},
+	}
+	selectors := &Data{
+		Service: Service{
+			HTTPRoutes: []idlerSelector{{Name: "lagoon.sh/autogenerated", Operator: "exists"}},
+		},
+	}
+	// patching twice must not overwrite the original rules with the idled rules
+	for i := 0; i < 2; i++ {
+		if _, err := h.patchHTTPRoutes(context.Background(), logr.Discard(), namespace, selectors); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := &gatewayv1.HTTPRoute{}
+	if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.Name, Name: "example"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Labels["idling.amazee.io/idled"] != "true" {
+		t.Errorf("httproute not labelled as idled")
+	}
+	backend := got.Spec.Rules[0].BackendRefs[0]
+	if backend.Name != "aergia-backend" || *backend.Namespace != "aergia" || *backend.Port != 80 {
+		t.Errorf("httproute backend = %v, want aergia/aergia-backend:80", backend)
+	}
+	originalRules := []gatewayv1.HTTPRouteRule{}
+	if err := json.Unmarshal([]byte(got.Annotations[UnidleRulesAnnotation]), &originalRules); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(originalRules, rules) {
+		t.Errorf("original rules = %v, want %v", originalRules, rules)
+	}
+}
@@ -0,0 +1,298 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The decisions recorded in a plan for each namespace.
+const (
+	DecisionIdle  = "idle"
+	DecisionSkip  = "skip"
+	DecisionError = "error"
+)
+
+// The reasons recorded in a plan for each decision.
+const (
+	PlanReasonNoHits         = "no-hits"
+	PlanReasonSkipHitCheck   = "hit-check-skipped"
+	PlanReasonForced         = "forced"
+	PlanReasonSchedule       = "schedule"
+	PlanReasonRunningBuild   = "running-build"
+	PlanReasonPodAge         = "pod-age"
+	PlanReasonMinAwake       = "min-awake"
+	PlanReasonFrozen         = "frozen"
+	PlanReasonHits           = "hits"
+	PlanReasonCronJobs       = "cronjobs"
+	PlanReasonProcesses      = "processes"
+	PlanReasonNoProcesses    = "no-processes"
+	PlanReasonAlreadyIdled   = "already-idled"
+	PlanReasonNoHitSource    = "no-hit-source"
+	PlanReasonHitsError      = "hits-error"
+	PlanReasonIngressFailure = "ingress-failure"
+	PlanReasonError          = "error"
+)
+
+// DecisionReasonDryRun is the reason recorded in the decision metrics when an environment would be idled in dry run mode.
+const DecisionReasonDryRun = "dry_run"
+
+// the reasons recorded in the decision metrics that don't match the reason in the plan
+var decisionReasons = map[string]string{
+	PlanReasonPodAge:         "pods_too_young",
+	PlanReasonHits:           "hits_present",
+	PlanReasonHitsError:      "prometheus_error",
+	PlanReasonIngressFailure: "ingress_patch_failed",
+	PlanReasonCronJobs:       "has_crons",
+	PlanReasonProcesses:      "processes_running",
+}
+
+// Plan is the report of a single idler run, with the decision made for each namespace that was evaluated.
+// When the idler is in dry run mode, the plan is what the idler would have done.
+type Plan struct {
+	Idler      string          `json:"idler"`
+	DryRun     bool            `json:"dryRun"`
+	Started    time.Time       `json:"started"`
+	Finished   time.Time       `json:"finished"`
+	Outcome    string          `json:"outcome,omitempty"`
+	Namespaces []NamespacePlan `json:"namespaces"`
+	lock       sync.Mutex
+}
+
+// NamespacePlan is the decision for a namespace, why it was made, and the resources that were or would be changed.
+type NamespacePlan struct {
+	Namespace    string   `json:"namespace"`
+	Project      string   `json:"project,omitempty"`
+	Environment  string   `json:"environment,omitempty"`
+	Decision     string   `json:"decision"`
+	Reason       string   `json:"reason"`
+	Message      string   `json:"message,omitempty"`
+	Hits         *int     `json:"hits,omitempty"`
+	PodInterval  string   `json:"podInterval,omitempty"`
+	HitInterval  string   `json:"hitInterval,omitempty"`
+	Deployments  []string `json:"deployments,omitempty"`
+	StatefulSets []string `json:"statefulSets,omitempty"`
+	CronJobs     []string `json:"cronJobs,omitempty"`
+	Ingresses    []string `json:"ingresses,omitempty"`
+	HTTPRoutes   []string `json:"httpRoutes,omitempty"`
+}
+
+type planKey struct{}
+
+type namespacePlanKey struct{}
+
+// withNamespacePlan adds the plan of a namespace to the context, so that the checks can record their decisions.
+func withNamespacePlan(ctx context.Context, plan *NamespacePlan) context.Context {
+	return context.WithValue(ctx, namespacePlanKey{}, plan)
+}
+
+// planFor returns the plan of the namespace being checked, or nil if there isn't one.
+func planFor(ctx context.Context) *NamespacePlan {
+	plan, _ := ctx.Value(namespacePlanKey{}).(*NamespacePlan)
+	return plan
+}
+
+// newNamespacePlan returns an empty plan for a namespace.
+func (h *Idler) newNamespacePlan(namespace corev1.Namespace) *NamespacePlan {
+	plan := &NamespacePlan{Namespace: namespace.Name}
+	if selectors := h.GetSelectors(); selectors != nil {
+		plan.Project = namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName]
+		plan.Environment = namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentName]
+	}
+	return plan
+}
+
+// IdleNamespace runs the service idler on a single namespace, and returns the decision that was made for it.
+func (h *Idler) IdleNamespace(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, forceIdle, forceScale bool) NamespacePlan {
+	plan := h.newNamespacePlan(namespace)
+	h.KubernetesServiceIdler(withNamespacePlan(ctx, plan), opLog, namespace, plan.Project, forceIdle, forceScale)
+	return *plan
+}
+
+// decide records the decision for the namespace. A decision to idle is kept over any later decision to skip, as the
+// cli idler checks each deployment and idles any of them that can be.
+func (p *NamespacePlan) decide(decision, reason, message string, args ...interface{}) {
+	if p == nil || (p.Decision == DecisionIdle && decision == DecisionSkip) {
+		return
+	}
+	p.Decision = decision
+	p.Reason = reason
+	p.Message = fmt.Sprintf(message, args...)
+}
+
+// recordDecision counts the decision made for a namespace in the decision metrics, keyed by the idler and reason.
+func (h *Idler) recordDecision(idler string, p *NamespacePlan) {
+	if p == nil || p.Decision == "" {
+		return
+	}
+	reason, ok := decisionReasons[p.Reason]
+	if !ok {
+		reason = strings.ReplaceAll(p.Reason, "-", "_")
+	}
+	if h.DryRun && p.Decision == DecisionIdle {
+		reason = DecisionReasonDryRun
+	}
+	metrics.IdlerDecisions.WithLabelValues(idler, p.Decision, reason).Inc()
+}
+
+// intervals records the intervals used to check the namespace.
+func (p *NamespacePlan) intervals(podInterval, hitInterval time.Duration) {
+	if p == nil {
+		return
+	}
+	p.PodInterval = podInterval.String()
+	p.HitInterval = hitInterval.String()
+}
+
+// hits records the number of hits to the namespace.
+func (p *NamespacePlan) hits(hits int) {
+	if p == nil {
+		return
+	}
+	p.Hits = &hits
+}
+
+// The kinds of resources recorded in a plan.
+const (
+	planDeployment  = "deployment"
+	planStatefulSet = "statefulset"
+	planCronJob     = "cronjob"
+	planIngress     = "ingress"
+	planHTTPRoute   = "httproute"
+)
+
+// touch records a resource that was or would be changed.
+func (p *NamespacePlan) touch(kind, name string) {
+	if p == nil {
+		return
+	}
+	switch kind {
+	case planDeployment:
+		p.Deployments = appendName(p.Deployments, name)
+	case planStatefulSet:
+		p.StatefulSets = appendName(p.StatefulSets, name)
+	case planCronJob:
+		p.CronJobs = appendName(p.CronJobs, name)
+	case planIngress:
+		p.Ingresses = appendName(p.Ingresses, name)
+	case planHTTPRoute:
+		p.HTTPRoutes = appendName(p.HTTPRoutes, name)
+	}
+}
+
+func appendName(names []string, name string) []string {
+	if slices.Contains(names, name) {
+		return names
+	}
+	return append(names, name)
+}
+
+// add adds a namespace to the plan, if a decision was made for it.
+func (p *Plan) add(plan *NamespacePlan) {
+	if plan.Decision == "" {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Namespaces = append(p.Namespaces, *plan)
+}
+
+// finish sorts the namespaces and records the outcome of the run.
+func (p *Plan) finish(outcome string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Finished = time.Now()
+	p.Outcome = outcome
+	sort.Slice(p.Namespaces, func(i, j int) bool { return p.Namespaces[i].Namespace < p.Namespaces[j].Namespace })
+}
+
+// WriteJSON writes the plan as json.
+func (p *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// WriteTable writes the plan as a table, with one row for each namespace.
+func (p *Plan) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "idler: %s, dry run: %t, started: %s, finished: %s, outcome: %s\n\n",
+		p.Idler, p.DryRun, p.Started.Format(time.RFC3339), p.Finished.Format(time.RFC3339), p.Outcome)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tDECISION\tREASON\tHITS\tPOD INTERVAL\tHIT INTERVAL\tDEPLOYMENTS\tINGRESSES\tMESSAGE")
+	for _, ns := range p.Namespaces {
+		hits := "-"
+		if ns.Hits != nil {
+			hits = strconv.Itoa(*ns.Hits)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			ns.Namespace, ns.Decision, ns.Reason, hits,
+			orDash(ns.PodInterval), orDash(ns.HitInterval),
+			orDash(strings.Join(ns.Deployments, ",")), orDash(strings.Join(append(ns.Ingresses, ns.HTTPRoutes...), ",")),
+			ns.Message)
+	}
+	return tw.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// Plan returns the plan of the last run of the named idler, or nil if it hasn't run yet.
+func (h *Idler) Plan(name string) *Plan {
+	plan, ok := h.plans.Load(name)
+	if !ok {
+		return nil
+	}
+	return plan.(*Plan)
+}
+
+// savePlan stores the plan as the last plan of the idler, and writes it to the plan directory if one is set.
+func (h *Idler) savePlan(plan *Plan) error {
+	h.plans.Store(plan.Idler, plan)
+	if h.PlanDir == "" {
+		return nil
+	}
+	for ext, write := range map[string]func(io.Writer) error{"json": plan.WriteJSON, "txt": plan.WriteTable} {
+		if err := writeFile(filepath.Join(h.PlanDir, fmt.Sprintf("%s-plan.%s", plan.Idler, ext)), write); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes to a temporary file and renames it, so that readers never see a partial plan.
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create plan file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if err := write(file); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write plan file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("unable to write plan file: %v", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("unable to write plan file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package idler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestIdler_Plan(t *testing.T) {
+	hits := 12
+	zero := 0
+	tests := []struct {
+		name      string
+		hitSource HitSource
+		idled     bool
+		want      NamespacePlan
+	}{
+		{
+			name:      "test1",
+			hitSource: &StaticHitSource{},
+			want: NamespacePlan{
+				Namespace:   "example-com-main",
+				Decision:    DecisionIdle,
+				Reason:      PlanReasonNoHits,
+				Message:     "pods have been running for more than 4h0m0s and it has had 0 hits in the last 4h0m0s",
+				Hits:        &zero,
+				PodInterval: "4h0m0s",
+				HitInterval: "4h0m0s",
+				Deployments: []string{"nginx"},
+			},
+		},
+		{
+			name:      "test2",
+			hitSource: &StaticHitSource{DefaultHits: 12},
+			want: NamespacePlan{
+				Namespace:   "example-com-main",
+				Decision:    DecisionSkip,
+				Reason:      PlanReasonHits,
+				Message:     "it has had 12 hits in the last 4h0m0s",
+				Hits:        &hits,
+				PodInterval: "4h0m0s",
+				HitInterval: "4h0m0s",
+			},
+		},
+		{
+			name:      "test3",
+			hitSource: &StaticHitSource{},
+			idled:     true,
+			want: NamespacePlan{
+				Namespace:   "example-com-main",
+				Decision:    DecisionSkip,
+				Reason:      PlanReasonAlreadyIdled,
+				Message:     "the deployments are already idled",
+				PodInterval: "4h0m0s",
+				HitInterval: "4h0m0s",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectors := readTestSelectors(t, "testdata/valid-selectors.yaml")
+			h, namespace := newTestIdler(t, selectors, tt.hitSource)
+			if tt.idled {
+				deployment := &appsv1.Deployment{}
+				if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.Name, Name: "nginx"}, deployment); err != nil {
+					t.Fatal(err)
+				}
+				deployment.Spec.Replicas = new(int32)
+				if err := h.Client.Update(context.Background(), deployment); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h.DryRun = true
+			h.PlanDir = t.TempDir()
+			h.runIdler(context.Background(), ServiceIdlerName, logr.Discard(), func(ctx context.Context) (int, error) {
+				return h.forEachNamespace(ctx, ServiceIdlerName, logr.Discard(), []corev1.Namespace{namespace}, func(ctx context.Context, namespace corev1.Namespace) {
+					h.KubernetesServiceIdler(ctx, logr.Discard(), namespace, "example-com", false, false)
+				}), nil
+			})
+			plan := h.Plan(ServiceIdlerName)
+			if plan == nil {
+				t.Fatal("Plan() = nil, want a plan")
+			}
+			if !plan.DryRun || plan.Outcome != RunSucceeded {
+				t.Errorf("plan dry run = %v, outcome = %s, want true, %s", plan.DryRun, plan.Outcome, RunSucceeded)
+			}
+			if len(plan.Namespaces) != 1 || !reflect.DeepEqual(plan.Namespaces[0], tt.want) {
+				t.Fatalf("plan namespaces = %+v, want %+v", plan.Namespaces, tt.want)
+			}
+			// the plan is written to the plan directory as json and as a table
+			b, err := os.ReadFile(filepath.Join(h.PlanDir, "service-plan.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			written := &Plan{}
+			if err := json.Unmarshal(b, written); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(written.Namespaces, plan.Namespaces) {
+				t.Errorf("written plan namespaces = %+v, want %+v", written.Namespaces, plan.Namespaces)
+			}
+			if _, err := os.Stat(filepath.Join(h.PlanDir, "service-plan.txt")); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestIdler_recordDecision(t *testing.T) {
+	tests := []struct {
+		name       string
+		dryRun     bool
+		plan       *NamespacePlan
+		wantReason string
+	}{
+		{
+			name:       "test1",
+			plan:       &NamespacePlan{Decision: DecisionSkip, Reason: PlanReasonPodAge},
+			wantReason: "pods_too_young",
+		},
+		{
+			name:       "test2",
+			plan:       &NamespacePlan{Decision: DecisionSkip, Reason: PlanReasonAlreadyIdled},
+			wantReason: "already_idled",
+		},
+		{
+			name:       "test3",
+			plan:       &NamespacePlan{Decision: DecisionError, Reason: PlanReasonHitsError},
+			wantReason: "prometheus_error",
+		},
+		{
+			name:       "test4",
+			plan:       &NamespacePlan{Decision: DecisionIdle, Reason: PlanReasonNoHits},
+			wantReason: "no_hits",
+		},
+		{
+			name:       "test5",
+			dryRun:     true,
+			plan:       &NamespacePlan{Decision: DecisionIdle, Reason: PlanReasonNoHits},
+			wantReason: DecisionReasonDryRun,
+		},
+		{
+			name:       "test6",
+			dryRun:     true,
+			plan:       &NamespacePlan{Decision: DecisionSkip, Reason: PlanReasonHits},
+			wantReason: "hits_present",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Idler{DryRun: tt.dryRun}
+			counter := metrics.IdlerDecisions.WithLabelValues(tt.name, tt.plan.Decision, tt.wantReason)
+			before := testutil.ToFloat64(counter)
+			h.recordDecision(tt.name, tt.plan)
+			// a namespace that wasn't evaluated has no decision, and isn't counted
+			h.recordDecision(tt.name, &NamespacePlan{})
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("decisions counted = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestPlan_WriteTable(t *testing.T) {
+	hits := 0
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	plan := &Plan{
+		Idler:    "service",
+		DryRun:   true,
+		Started:  started,
+		Finished: started.Add(time.Minute),
+		Outcome:  RunSucceeded,
+		Namespaces: []NamespacePlan{
+			{
+				Namespace:   "example-com-main",
+				Decision:    DecisionIdle,
+				Reason:      PlanReasonNoHits,
+				Message:     "no hits",
+				Hits:        &hits,
+				PodInterval: "4h0m0s",
+				HitInterval: "4h0m0s",
+				Deployments: []string{"nginx", "php"},
+				Ingresses:   []string{"example-com"},
+			},
+			{
+				Namespace: "example-com-dev",
+				Decision:  DecisionSkip,
+				Reason:    PlanReasonRunningBuild,
+				Message:   "a build is running",
+			},
+		},
+	}
+	want := `idler: service, dry run: true, started: 2026-01-02T03:04:05Z, finished: 2026-01-02T03:05:05Z, outcome: succeeded
+
+NAMESPACE         DECISION  REASON         HITS  POD INTERVAL  HIT INTERVAL  DEPLOYMENTS  INGRESSES    MESSAGE
+example-com-main  idle      no-hits        0     4h0m0s        4h0m0s        nginx,php    example-com  no hits
+example-com-dev   skip      running-build  -     -             -             -            -            a build is running
+`
+	var b bytes.Buffer
+	if err := plan.WriteTable(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Errorf("WriteTable() = \n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,68 @@
+package idler
+
+import (
+	idlingv1alpha1 "github.com/uselagoon/aergia-controller/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// NewDataFromPolicy converts an IdlingPolicy spec into the selectors used by the idler.
+func NewDataFromPolicy(spec idlingv1alpha1.IdlingPolicySpec) *Data {
+	d := &Data{
+		NamespaceSelectorsLabels: NamespaceSelectorsLabels{
+			ProjectName:       spec.NamespaceSelectorsLabels.ProjectName,
+			EnvironmentName:   spec.NamespaceSelectorsLabels.EnvironmentName,
+			ProjectIdling:     spec.NamespaceSelectorsLabels.ProjectIdling,
+			EnvironmentIdling: spec.NamespaceSelectorsLabels.EnvironmentIdling,
+			EnvironmentType:   spec.NamespaceSelectorsLabels.EnvironmentType,
+		},
+		ServiceName: spec.ServiceName,
+		CLI: CLI{
+			SkipBuildCheck:   spec.CLI.SkipBuildCheck,
+			SkipCronCheck:    spec.CLI.SkipCronCheck,
+			SkipProcessCheck: spec.CLI.SkipProcessCheck,
+			Namespace:        convertSelectors(spec.CLI.Namespace),
+			Builds:           convertSelectors(spec.CLI.Builds),
+			Deployments:      convertSelectors(spec.CLI.Deployments),
+			Pods:             convertSelectors(spec.CLI.Pods),
+		},
+		Service: Service{
+			SkipBuildCheck:   spec.Service.SkipBuildCheck,
+			SkipHitCheck:     spec.Service.SkipHitCheck,
+			SkipIngressPatch: spec.Service.SkipIngressPatch,
+			Namespace:        convertSelectors(spec.Service.Namespace),
+			Builds:           convertSelectors(spec.Service.Builds),
+			Deployments:      convertSelectors(spec.Service.Deployments),
+			Pods:             convertSelectors(spec.Service.Pods),
+			Ingress:          convertSelectors(spec.Service.Ingress),
+			StatefulSets:     convertSelectors(spec.Service.StatefulSets),
+			CronJobs:         convertSelectors(spec.Service.CronJobs),
+			HTTPRoutes:       convertSelectors(spec.Service.HTTPRoutes),
+			HitStatusRegex:   spec.Service.HitStatusRegex,
+		},
+	}
+	if spec.Service.PodCheckInterval != nil {
+		d.Service.PodCheckInterval = spec.Service.PodCheckInterval.Duration
+	}
+	if spec.Service.PrometheusCheckInterval != nil {
+		d.Service.PrometheusCheckInterval = spec.Service.PrometheusCheckInterval.Duration
+	}
+	for _, q := range spec.Service.HitQueries {
+		d.Service.HitQueries = append(d.Service.HitQueries, HitQuery{Name: q.Name, Query: q.Query})
+	}
+	return d
+}
+
+func convertSelectors(selectors []idlingv1alpha1.Selector) []idlerSelector {
+	if selectors == nil {
+		return nil
+	}
+	converted := []idlerSelector{}
+	for _, s := range selectors {
+		converted = append(converted, idlerSelector{
+			Name:     s.Name,
+			Operator: selection.Operator(s.Operator),
+			Values:   s.Values,
+		})
+	}
+	return converted
+}
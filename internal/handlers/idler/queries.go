@@ -0,0 +1,152 @@
+package idler
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	prometheusmodel "github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultHitStatusRegex is the status code regex used by the hit queries if none is defined.
+const DefaultHitStatusRegex = `2[0-9x]{2}`
+
+// noServicesRegex never matches anything, not even an empty label, so that a namespace without services has no hits.
+const noServicesRegex = `.^`
+
+// HitQuery is a named go template that renders a promql query returning the number of hits to a namespace.
+type HitQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// DefaultHitQueries are used if no hit queries are defined in the selectors.
+var DefaultHitQueries = []HitQuery{
+	{
+		Name:  "nginx",
+		Query: `round(sum(increase(nginx_ingress_controller_requests{exported_namespace="{{ .Namespace }}",status=~"{{ .StatusRegex }}"}[{{ .Interval }}])) by (status))`,
+	},
+	{
+		// traefik names the services of an ingress `<namespace>-<service>-<port>@<provider>`, where the port is the
+		// name or number of the service port
+		Name:  "traefik",
+		Query: `round(sum(increase(traefik_service_requests_total{exported_service=~"{{ .Namespace }}-({{ .ServicePorts }})@.+",code=~"{{ .StatusRegex }}"}[{{ .Interval }}])) by (code))`,
+	},
+	{
+		// envoy gateway names the clusters of an HTTPRoute `httproute/<namespace>/<name>/rule/<index>`
+		Name:  "gateway",
+		Query: `round(sum(increase(envoy_cluster_upstream_rq{envoy_cluster_name=~"httproute/{{ .Namespace }}/.*",envoy_response_code=~"{{ .StatusRegex }}"}[{{ .Interval }}])) by (envoy_response_code))`,
+	},
+}
+
+// hitQueryVars are the variables available to a hit query template.
+type hitQueryVars struct {
+	Namespace   string
+	Project     string
+	Environment string
+	Interval    string
+	StatusRegex string
+	// Services is a regex matching the names of the services in the namespace.
+	Services string
+	// ServicePorts is a regex matching `<service>-<port>` for the name and number of each port of each service.
+	ServicePorts string
+}
+
+// hitQueries returns the hit queries and status code regex to use, falling back to the defaults.
+func (d *Data) hitQueries() ([]HitQuery, string) {
+	queries := DefaultHitQueries
+	statusRegex := DefaultHitStatusRegex
+	if d == nil {
+		return queries, statusRegex
+	}
+	if len(d.Service.HitQueries) > 0 {
+		queries = d.Service.HitQueries
+	}
+	if d.Service.HitStatusRegex != "" {
+		statusRegex = d.Service.HitStatusRegex
+	}
+	return queries, statusRegex
+}
+
+// validateHitQueries checks that the hit queries are valid templates, and the status regex compiles.
+func (d *Data) validateHitQueries() []string {
+	errs := []string{}
+	if d.Service.HitStatusRegex != "" {
+		if _, err := regexp.Compile(d.Service.HitStatusRegex); err != nil {
+			errs = append(errs, fmt.Sprintf("service.hitstatusregex: %v", err))
+		}
+	}
+	for idx, q := range d.Service.HitQueries {
+		if q.Name == "" {
+			errs = append(errs, fmt.Sprintf("service.hitqueries[%d]: name is required", idx))
+		}
+		if _, err := renderHitQuery(q, hitQueryVars{}); err != nil {
+			errs = append(errs, fmt.Sprintf("service.hitqueries[%d]: %v", idx, err))
+		}
+	}
+	return errs
+}
+
+// newHitQueryVars returns the template variables for a namespace, the project and environment are read from the
+// namespace labels defined in the selectors. The services and their ports match anything until they are set from the
+// namespace.
+func newHitQueryVars(d *Data, namespace corev1.Namespace, interval time.Duration, statusRegex string) hitQueryVars {
+	vars := hitQueryVars{
+		Namespace:    namespace.Name,
+		Interval:     prometheusmodel.Duration(interval).String(),
+		StatusRegex:  statusRegex,
+		Services:     ".+",
+		ServicePorts: ".+",
+	}
+	if d != nil {
+		vars.Project = namespace.Labels[d.NamespaceSelectorsLabels.ProjectName]
+		vars.Environment = namespace.Labels[d.NamespaceSelectorsLabels.EnvironmentName]
+	}
+	return vars
+}
+
+// serviceNamesRegex returns a regex that only matches the names of the services.
+func serviceNamesRegex(services []corev1.Service) string {
+	names := []string{}
+	for _, service := range services {
+		names = append(names, regexp.QuoteMeta(service.Name))
+	}
+	if len(names) == 0 {
+		return noServicesRegex
+	}
+	return strings.Join(names, "|")
+}
+
+// servicePortsRegex returns a regex that only matches `<service>-<port>` for the name and number of each port of the
+// services, the same way traefik names the service of an ingress backend.
+func servicePortsRegex(services []corev1.Service) string {
+	ports := []string{}
+	for _, service := range services {
+		for _, port := range service.Spec.Ports {
+			ports = append(ports, regexp.QuoteMeta(fmt.Sprintf("%s-%d", service.Name, port.Port)))
+			if port.Name != "" {
+				ports = append(ports, regexp.QuoteMeta(fmt.Sprintf("%s-%s", service.Name, port.Name)))
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return noServicesRegex
+	}
+	return strings.Join(ports, "|")
+}
+
+func renderHitQuery(q HitQuery, vars hitQueryVars) (string, error) {
+	tmpl, err := template.New(q.Name).Option("missingkey=error").Parse(q.Query)
+	if err != nil {
+		return "", err
+	}
+	var query bytes.Buffer
+	if err := tmpl.Execute(&query, vars); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(query.String()), nil
+}
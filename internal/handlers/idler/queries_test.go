@@ -0,0 +1,224 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	prometheusapi "github.com/prometheus/client_golang/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRenderHitQuery(t *testing.T) {
+	namespace := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "example-com-main",
+			Labels: map[string]string{
+				"lagoon.sh/project":     "example-com",
+				"lagoon.sh/environment": "main",
+			},
+		},
+	}
+	selectors := &Data{
+		NamespaceSelectorsLabels: NamespaceSelectorsLabels{
+			ProjectName:     "lagoon.sh/project",
+			EnvironmentName: "lagoon.sh/environment",
+		},
+	}
+	tests := []struct {
+		name        string
+		query       HitQuery
+		statusRegex string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "test1",
+			query:       DefaultHitQueries[0],
+			statusRegex: DefaultHitStatusRegex,
+			want:        `round(sum(increase(nginx_ingress_controller_requests{exported_namespace="example-com-main",status=~"2[0-9x]{2}"}[4h])) by (status))`,
+		},
+		{
+			name: "test2",
+			query: HitQuery{
+				Name:  "traefik",
+				Query: `sum(increase(traefik_service_requests_total{exported_service=~"{{ .Namespace }}-{{ .Project }}-.*",code=~"{{ .StatusRegex }}"}[{{ .Interval }}]))`,
+			},
+			statusRegex: "[23][0-9]{2}",
+			want:        `sum(increase(traefik_service_requests_total{exported_service=~"example-com-main-example-com-.*",code=~"[23][0-9]{2}"}[4h]))`,
+		},
+		{
+			name: "test3",
+			query: HitQuery{
+				Name:  "missing",
+				Query: `sum(requests{environment="{{ .Branch }}"})`,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderHitQuery(tt.query, newHitQueryVars(selectors, namespace, 4*time.Hour, tt.statusRegex))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderHitQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderHitQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrometheusHitSource(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	services := []client.Object{
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "example-com-main"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 8080}}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "solr.search", Namespace: "example-com-main"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8983}}},
+		},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "example-com-main-pr-1"}},
+	}
+	tests := []struct {
+		name       string
+		queries    []HitQuery
+		kubeClient bool
+		want       []string
+	}{
+		{
+			name: "test1",
+			queries: []HitQuery{
+				{Name: "first", Query: `requests{namespace="{{ .Namespace }}"}`},
+				{Name: "second", Query: `other_requests{namespace="{{ .Namespace }}"}`},
+			},
+			want: []string{`requests{namespace="example-com-main"}`, `other_requests{namespace="example-com-main"}`},
+		},
+		{
+			name:       "test2",
+			queries:    []HitQuery{DefaultHitQueries[1], {Name: "second", Query: `requests{service=~"{{ .Services }}"}`}},
+			kubeClient: true,
+			want: []string{
+				`round(sum(increase(traefik_service_requests_total{exported_service=~"example-com-main-(nginx-8080|nginx-http|solr\.search-8983)@.+",code=~"2[0-9x]{2}"}[1h])) by (code))`,
+				`requests{service=~"nginx|solr\.search"}`,
+			},
+		},
+		{
+			name:    "test3",
+			queries: []HitQuery{{Name: "first", Query: `requests{service=~"{{ .Services }}"}`}, {Name: "second", Query: `requests`}},
+			want:    []string{`requests{service=~".+"}`, `requests`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Error(err)
+				}
+				queries = append(queries, r.Form.Get("query"))
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"status":"200"},"value":[1700000000,"5"]},{"metric":{"status":"204"},"value":[1700000000,"2"]}]}}`)
+			}))
+			defer server.Close()
+			promClient, err := prometheusapi.NewClient(prometheusapi.Config{Address: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			selectors := &Data{
+				Service: Service{
+					HitQueries: tt.queries,
+				},
+			}
+			source := &PrometheusHitSource{
+				Client:    promClient,
+				Selectors: func() *Data { return selectors },
+			}
+			if tt.kubeClient {
+				source.KubeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(services...).Build()
+			}
+			namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}}
+			hits, err := source.Hits(context.Background(), logr.Discard(), namespace, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hits != 14 {
+				t.Errorf("Hits() = %d, want 14", hits)
+			}
+			if fmt.Sprint(queries) != fmt.Sprint(tt.want) {
+				t.Errorf("queries = %v, want %v", queries, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultTraefikHitQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		services  []corev1.Service
+		match     []string
+		noMatch   []string
+	}{
+		{
+			name:      "test1",
+			namespace: "foo",
+			services: []corev1.Service{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "bar"},
+					Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+				},
+			},
+			match: []string{"foo-bar-80@kubernetes", "foo-bar-http@kubernetescrd"},
+			// the services of namespace foo-bar, or service bar-baz, must not be counted
+			noMatch: []string{"foo-bar-baz-80@kubernetes", "foo-bar-baz-http@kubernetes", "foo-bar-8080@kubernetes", "foo-bar-80"},
+		},
+		{
+			name:      "test2",
+			namespace: "foo",
+			noMatch:   []string{"foo--80@kubernetes", "foo-bar-80@kubernetes", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := newHitQueryVars(nil, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tt.namespace}}, time.Hour, DefaultHitStatusRegex)
+			vars.Services = serviceNamesRegex(tt.services)
+			vars.ServicePorts = servicePortsRegex(tt.services)
+			query, err := renderHitQuery(DefaultHitQueries[1], vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matches := regexp.MustCompile(`exported_service=~"([^"]+)"`).FindStringSubmatch(query)
+			if matches == nil {
+				t.Fatalf("renderHitQuery() = %s, want an exported_service matcher", query)
+			}
+			// prometheus anchors label regexes
+			serviceRegex := regexp.MustCompile("^(?:" + matches[1] + ")$")
+			for _, service := range tt.match {
+				if !serviceRegex.MatchString(service) {
+					t.Errorf("%s does not match %s", matches[1], service)
+				}
+			}
+			for _, service := range tt.noMatch {
+				if serviceRegex.MatchString(service) {
+					t.Errorf("%s matches %s", matches[1], service)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package idler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The outcomes recorded for each idler run.
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+	RunTimedOut  = "timed-out"
+	RunSkipped   = "skipped"
+	RunCancelled = "cancelled"
+)
+
+// The names of the idlers, used to stop runs of the same idler from overlapping.
+const (
+	ServiceIdlerName  = "service"
+	CLIIdlerName      = "cli"
+	ScheduleIdlerName = "schedule"
+)
+
+// IsRunning returns true if a run of the named idler is in progress.
+func (h *Idler) IsRunning(name string) bool {
+	_, ok := h.runs.Load(name)
+	return ok
+}
+
+/*
+runIdler runs an idler, unless a previous run of the same idler is still in progress, and records how long the run
+took and its outcome. A run has timed out if it checked every namespace, but some of them took longer than the
+namespace timeout. The run is cancelled with ctx, when the manager stops or this replica is no longer the leader.
+*/
+func (h *Idler) runIdler(ctx context.Context, name string, opLog logr.Logger, run func(ctx context.Context) (int, error)) {
+	if _, running := h.runs.LoadOrStore(name, true); running {
+		opLog.Info(fmt.Sprintf("Skipping %s idler run, the previous run is still in progress", name))
+		metrics.IdlerRuns.WithLabelValues(name, RunSkipped).Inc()
+		return
+	}
+	defer h.runs.Delete(name)
+	start := time.Now()
+	plan := &Plan{Idler: name, DryRun: h.DryRun, Started: start, Namespaces: []NamespacePlan{}}
+	ctx, span := tracing.Start(context.WithValue(ctx, planKey{}, plan), "IdlerRun", tracing.AttributeIdler.String(name))
+	timeouts, err := run(ctx)
+	outcome := RunSucceeded
+	switch {
+	case err != nil:
+		outcome = RunFailed
+	case ctx.Err() != nil:
+		outcome = RunCancelled
+	case timeouts > 0:
+		outcome = RunTimedOut
+	}
+	duration := time.Since(start)
+	plan.finish(outcome)
+	span.SetAttributes(tracing.AttributeOutcome.String(outcome), attribute.Int("aergia.namespaces", len(plan.Namespaces)))
+	tracing.End(span, err)
+	metrics.IdlerRuns.WithLabelValues(name, outcome).Inc()
+	metrics.IdlerRunDuration.WithLabelValues(name, outcome).Observe(duration.Seconds())
+	metrics.IdlerLastRunDuration.WithLabelValues(name).Set(duration.Seconds())
+	metrics.IdlerNamespacesEvaluated.WithLabelValues(name).Set(float64(len(plan.Namespaces)))
+	if outcome == RunSucceeded {
+		metrics.IdlerLastSuccess.WithLabelValues(name).Set(float64(plan.Finished.Unix()))
+	}
+	opLog.Info(fmt.Sprintf("Finished %s idler run in %s, outcome %s, %d namespaces evaluated, %d namespaces timed out",
+		name, duration.Round(time.Millisecond), outcome, len(plan.Namespaces), timeouts))
+	if err := h.savePlan(plan); err != nil {
+		opLog.Error(err, fmt.Sprintf("Unable to save the %s idler plan", name))
+	}
+}
+
+/*
+forEachNamespace checks the namespaces with a pool of workers, the size of the pool is the concurrency of the idler.
+Each check is given the namespace timeout, and the number of namespaces that took longer than the timeout is returned.
+No more namespaces are checked once ctx is cancelled.
+*/
+func (h *Idler) forEachNamespace(ctx context.Context, name string, opLog logr.Logger, namespaces []corev1.Namespace, check func(ctx context.Context, namespace corev1.Namespace)) int {
+	workers := h.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan corev1.Namespace)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	timeouts := 0
+	for range workers {
+		wg.Go(func() {
+			for namespace := range queue {
+				if !h.checkNamespace(ctx, name, namespace, check) {
+					opLog.Info(fmt.Sprintf("Checking namespace %s timed out after %s", namespace.Name, h.NamespaceTimeout))
+					metrics.IdlerNamespaceTimeouts.WithLabelValues(name).Inc()
+					lock.Lock()
+					timeouts++
+					lock.Unlock()
+				}
+			}
+		})
+	}
+queue:
+	for _, namespace := range namespaces {
+		// a worker may be ready when ctx is cancelled, so check it first
+		if ctx.Err() != nil {
+			opLog.Info(fmt.Sprintf("Stopping %s idler run, %v", name, ctx.Err()))
+			break
+		}
+		select {
+		case queue <- namespace:
+		case <-ctx.Done():
+			opLog.Info(fmt.Sprintf("Stopping %s idler run, %v", name, ctx.Err()))
+			break queue
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return timeouts
+}
+
+// checkNamespace runs a check with the namespace timeout, it returns false if the check took longer than the timeout.
+// The decision made for the namespace is added to the plan of the run, and counted in the decision metrics.
+func (h *Idler) checkNamespace(ctx context.Context, name string, namespace corev1.Namespace, check func(ctx context.Context, namespace corev1.Namespace)) bool {
+	nsPlan := h.newNamespacePlan(namespace)
+	defer h.recordDecision(name, nsPlan)
+	if plan, ok := ctx.Value(planKey{}).(*Plan); ok {
+		defer plan.add(nsPlan)
+	}
+	ctx, span := tracing.Start(ctx, "CheckNamespace", tracing.AttributeIdler.String(name), tracing.AttributeNamespace.String(namespace.Name))
+	defer func() {
+		span.SetAttributes(tracing.AttributeDecision.String(nsPlan.Decision), tracing.AttributeReason.String(nsPlan.Reason))
+		span.End()
+	}()
+	ctx = withNamespacePlan(ctx, nsPlan)
+	if h.NamespaceTimeout <= 0 {
+		check(ctx, namespace)
+		return true
+	}
+	nsCtx, cancel := context.WithTimeout(ctx, h.NamespaceTimeout)
+	defer cancel()
+	check(nsCtx, namespace)
+	if errors.Is(nsCtx.Err(), context.DeadlineExceeded) {
+		nsPlan.decide(DecisionError, PlanReasonError, "checking the namespace timed out after %s", h.NamespaceTimeout)
+		return false
+	}
+	return true
+}
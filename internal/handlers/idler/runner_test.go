@@ -0,0 +1,140 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIdler_forEachNamespace(t *testing.T) {
+	tests := []struct {
+		name             string
+		concurrency      int
+		namespaceTimeout time.Duration
+		slow             map[string]bool
+		wantParallel     int32
+		wantTimeouts     int
+	}{
+		{
+			name:         "test1",
+			wantParallel: 1,
+		},
+		{
+			name:         "test2",
+			concurrency:  4,
+			wantParallel: 4,
+		},
+		{
+			name:             "test3",
+			concurrency:      2,
+			namespaceTimeout: 50 * time.Millisecond,
+			slow:             map[string]bool{"namespace-1": true, "namespace-5": true},
+			wantParallel:     2,
+			wantTimeouts:     2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Idler{Concurrency: tt.concurrency, NamespaceTimeout: tt.namespaceTimeout}
+			namespaces := []corev1.Namespace{}
+			for i := range 8 {
+				namespaces = append(namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("namespace-%d", i)}})
+			}
+			var running, maxRunning int32
+			var lock sync.Mutex
+			checked := map[string]bool{}
+			timeouts := h.forEachNamespace(context.Background(), "test", logr.Discard(), namespaces, func(ctx context.Context, namespace corev1.Namespace) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				lock.Lock()
+				checked[namespace.Name] = true
+				if n > maxRunning {
+					maxRunning = n
+				}
+				lock.Unlock()
+				if tt.slow[namespace.Name] {
+					<-ctx.Done()
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			})
+			if len(checked) != len(namespaces) {
+				t.Errorf("checked %d namespaces, want %d", len(checked), len(namespaces))
+			}
+			if maxRunning != tt.wantParallel {
+				t.Errorf("parallel checks = %d, want %d", maxRunning, tt.wantParallel)
+			}
+			if timeouts != tt.wantTimeouts {
+				t.Errorf("timeouts = %d, want %d", timeouts, tt.wantTimeouts)
+			}
+		})
+	}
+}
+
+func TestIdler_runIdler(t *testing.T) {
+	h := &Idler{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	runs := int32(0)
+	run := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&runs, 1)
+		close(started)
+		<-release
+		return 0, nil
+	}
+	done := make(chan struct{})
+	go func() {
+		h.runIdler(context.Background(), "test", logr.Discard(), run)
+		close(done)
+	}()
+	<-started
+	if !h.IsRunning("test") {
+		t.Fatalf("IsRunning() = false, want true")
+	}
+	// a second run while the first is in progress is skipped
+	h.runIdler(context.Background(), "test", logr.Discard(), run)
+	close(release)
+	<-done
+	if runs != 1 {
+		t.Errorf("runs = %d, want 1", runs)
+	}
+	if h.IsRunning("test") {
+		t.Errorf("IsRunning() = true, want false")
+	}
+	if got := testutil.ToFloat64(metrics.IdlerLastSuccess.WithLabelValues("test")); got < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("last success = %v, want the time of the run", got)
+	}
+}
+
+func TestIdler_runIdlerCancelled(t *testing.T) {
+	h := &Idler{Concurrency: 1}
+	namespaces := []corev1.Namespace{}
+	for i := range 4 {
+		namespaces = append(namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("namespace-%d", i)}})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := testutil.ToFloat64(metrics.IdlerRuns.WithLabelValues("cancelled", RunCancelled))
+	checked := 0
+	h.runIdler(ctx, "cancelled", logr.Discard(), func(ctx context.Context) (int, error) {
+		return h.forEachNamespace(ctx, "cancelled", logr.Discard(), namespaces, func(ctx context.Context, namespace corev1.Namespace) {
+			checked++
+			// the manager stops while the first namespace is being checked
+			cancel()
+		}), nil
+	})
+	if checked != 1 {
+		t.Errorf("checked %d namespaces, want 1", checked)
+	}
+	if got := testutil.ToFloat64(metrics.IdlerRuns.WithLabelValues("cancelled", RunCancelled)); got != cancelled+1 {
+		t.Errorf("cancelled runs = %v, want %v", got, cancelled+1)
+	}
+}
@@ -0,0 +1,322 @@
+package idler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	client "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ScheduleAnnotation is the namespace annotation that defines when an environment should be awake.
+	ScheduleAnnotation = "idling.amazee.io/schedule"
+	// ScheduleIdledLabel is set on namespaces that have been idled because they are outside of their schedule.
+	ScheduleIdledLabel = "idling.amazee.io/schedule-idled"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// scheduleWindow is a single awake window, start and end are minutes from midnight.
+type scheduleWindow struct {
+	days     [7]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+type schedule []scheduleWindow
+
+/*
+parseSchedule parses the value of the schedule annotation. Multiple windows are separated by `;`, and each window is
+in the format `awake <days> <HH:MM>-<HH:MM> [timezone]`, for example `awake Mon-Fri 08:00-19:00 Europe/Zurich`.
+Days can be a single day, a range, or a comma separated list of both. If the end time is before the start time, the
+window runs over midnight into the next day. The timezone defaults to UTC.
+*/
+func parseSchedule(value string) (schedule, error) {
+	s := schedule{}
+	for _, w := range strings.Split(value, ";") {
+		if strings.TrimSpace(w) == "" {
+			continue
+		}
+		fields := strings.Fields(w)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("invalid schedule window %q", strings.TrimSpace(w))
+		}
+		if strings.ToLower(fields[0]) != "awake" {
+			return nil, fmt.Errorf("invalid schedule window %q, must start with awake", strings.TrimSpace(w))
+		}
+		window := scheduleWindow{location: time.UTC}
+		days, err := parseDays(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		window.days = days
+		times := strings.Split(fields[2], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("invalid schedule times %q", fields[2])
+		}
+		if window.start, err = parseClock(times[0]); err != nil {
+			return nil, err
+		}
+		if window.end, err = parseClock(times[1]); err != nil {
+			return nil, err
+		}
+		if window.start == window.end {
+			return nil, fmt.Errorf("invalid schedule times %q, start and end are the same", fields[2])
+		}
+		if len(fields) == 4 {
+			if window.location, err = time.LoadLocation(fields[3]); err != nil {
+				return nil, fmt.Errorf("invalid schedule timezone %q: %v", fields[3], err)
+			}
+		}
+		s = append(s, window)
+	}
+	if len(s) == 0 {
+		return nil, fmt.Errorf("schedule has no windows")
+	}
+	return s, nil
+}
+
+func parseDays(value string) ([7]bool, error) {
+	days := [7]bool{}
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return days, fmt.Errorf("invalid schedule days %q", value)
+		}
+		first, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return days, fmt.Errorf("invalid schedule day %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return days, fmt.Errorf("invalid schedule day %q", bounds[1])
+			}
+		}
+		// ranges can wrap around the week, eg Fri-Mon
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid schedule time %q", value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid schedule time %q", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid schedule time %q", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+// awake returns true if the given time falls inside any of the windows in the schedule.
+func (s schedule) awake(t time.Time) bool {
+	for _, w := range s {
+		local := t.In(w.location)
+		minutes := local.Hour()*60 + local.Minute()
+		day := local.Weekday()
+		if w.start < w.end {
+			if w.days[day] && minutes >= w.start && minutes < w.end {
+				return true
+			}
+			continue
+		}
+		// the window runs over midnight, so check the start day and the morning after it
+		if w.days[day] && minutes >= w.start {
+			return true
+		}
+		if w.days[(day+6)%7] && minutes < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+scheduledIdle handles namespaces that have a schedule annotation. If the namespace is outside of its awake windows
+it is force idled once and labelled so that it isn't idled again if someone unidles it. A namespace that is already
+idled is not labelled, as the schedule didn't idle it. When the namespace is back inside an awake window, the label is
+removed and the namespace is labelled to be unidled by the controller.
+It returns true if the schedule has been acted on, and no further checks are needed.
+*/
+func (h *Idler) scheduledIdle(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, projectName string, now time.Time) bool {
+	value, ok := namespace.Annotations[ScheduleAnnotation]
+	if !ok {
+		return false
+	}
+	s, err := parseSchedule(value)
+	if err != nil {
+		opLog.Info(fmt.Sprintf("Unable to parse schedule, ignoring: %v", err))
+		return false
+	}
+	scheduleIdled := namespace.Labels[ScheduleIdledLabel] == "true"
+	if s.awake(now) {
+		if !scheduleIdled {
+			return false
+		}
+		planFor(ctx).decide(DecisionSkip, PlanReasonSchedule, "the environment is inside its schedule and will be unidled")
+		if h.DryRun {
+			opLog.Info("Environment is inside its schedule and would be unidled")
+			return true
+		}
+		opLog.Info("Environment is inside its schedule, unidling")
+		if err := h.patchNamespaceLabels(ctx, namespace, map[string]interface{}{
+			ScheduleIdledLabel:        nil,
+			"idling.amazee.io/unidle": "true",
+		}); err != nil {
+			opLog.Error(err, "Error patching namespace")
+		}
+		return true
+	}
+	if scheduleIdled {
+		// it has already been idled by the schedule, if it was unidled since then, let the normal checks handle it
+		if h.Debug {
+			opLog.Info("Environment is outside its schedule and has already been idled")
+		}
+		return false
+	}
+	if freeze, ok := h.frozen(namespace, now); ok {
+		// unidling still happens during a freeze, only idling is stopped
+		opLog.Info(fmt.Sprintf("Environment is outside its schedule but not idled, %s", freeze))
+		planFor(ctx).decide(DecisionSkip, PlanReasonFrozen, "%s", freeze)
+		return true
+	}
+	running, err := h.runningDeployments(ctx, namespace)
+	if err != nil {
+		opLog.Error(err, "Error getting deployments")
+		planFor(ctx).decide(DecisionError, PlanReasonError, "error getting deployments: %v", err)
+		return true
+	}
+	if !running {
+		// it was idled by the service idler or by hand, so it isn't unidled when the next window starts
+		if h.Debug {
+			opLog.Info("Environment is outside its schedule and is already idled")
+		}
+		planFor(ctx).decide(DecisionSkip, PlanReasonAlreadyIdled, "the environment is outside of its schedule and is already idled")
+		return true
+	}
+	opLog.Info("Environment is outside its schedule, force idling")
+	if !h.KubernetesServiceIdler(ctx, opLog, namespace, projectName, true, false) {
+		return true
+	}
+	planFor(ctx).decide(DecisionIdle, PlanReasonSchedule, "the environment is outside of its schedule")
+	if !h.DryRun {
+		if err := h.patchNamespaceLabels(ctx, namespace, map[string]interface{}{
+			ScheduleIdledLabel: "true",
+		}); err != nil {
+			opLog.Error(err, "Error patching namespace")
+		}
+	}
+	return true
+}
+
+// runningDeployments returns true if any of the deployments the idler manages in the namespace have replicas.
+func (h *Idler) runningDeployments(ctx context.Context, namespace corev1.Namespace) (bool, error) {
+	labelRequirements, err := generateLabelRequirements(h.GetSelectors().Service.Deployments)
+	if err != nil {
+		return false, err
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := h.Client.List(ctx, deployments, client.InNamespace(namespace.Name), client.MatchingLabelsSelector{
+		Selector: labels.NewSelector().Add(labelRequirements...),
+	}); err != nil {
+		return false, err
+	}
+	for _, deployment := range deployments.Items {
+		// a deployment without replicas set has the default of 1
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (h *Idler) patchNamespaceLabels(ctx context.Context, namespace corev1.Namespace, nsLabels map[string]interface{}) error {
+	namespaceCopy := namespace.DeepCopy()
+	mergePatch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": nsLabels,
+		},
+	})
+	if err := h.Client.Patch(ctx, namespaceCopy, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+		return fmt.Errorf("error patching namespace %s: %v", namespace.Name, err)
+	}
+	return nil
+}
+
+// ScheduleIdler will run the schedule idler process, it only checks namespaces that have a schedule annotation.
+func (h *Idler) ScheduleIdler(ctx context.Context) {
+	h.runIdler(ctx, ScheduleIdlerName, h.Log.WithName("aergia-controller").WithName("ScheduleIdler"), h.scheduleIdler)
+}
+
+func (h *Idler) scheduleIdler(ctx context.Context) (int, error) {
+	opLog := h.Log.WithName("aergia-controller").WithName("ScheduleIdler")
+	selectors := h.GetSelectors()
+	labelRequirements, err := generateLabelRequirements(selectors.Service.Namespace)
+	if err != nil {
+		opLog.Error(err, "unable to generate namespace selectors")
+		return 0, err
+	}
+	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
+		client.MatchingLabelsSelector{
+			Selector: labels.NewSelector().Add(labelRequirements...),
+		},
+	})
+	namespaces := &corev1.NamespaceList{}
+	if err := h.Client.List(ctx, namespaces, listOption); err != nil {
+		opLog.Error(err, "unable to get any namespaces")
+		return 0, err
+	}
+	now := time.Now()
+	scheduled := []corev1.Namespace{}
+	for _, namespace := range namespaces.Items {
+		if _, ok := namespace.Annotations[ScheduleAnnotation]; ok {
+			scheduled = append(scheduled, namespace)
+		}
+	}
+	return h.forEachNamespace(ctx, ScheduleIdlerName, opLog, scheduled, func(ctx context.Context, namespace corev1.Namespace) {
+		projectAutoIdle, ok1 := namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectIdling]
+		environmentAutoIdle, ok2 := namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentIdling]
+		if ok1 && ok2 && environmentAutoIdle == "1" && projectAutoIdle == "1" {
+			envOpLog := opLog.WithValues("namespace", namespace.Name).
+				WithValues("project", namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName]).
+				WithValues("environment", namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentName]).
+				WithValues("dry-run", h.DryRun)
+			h.scheduledIdle(ctx, envOpLog, namespace, namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName], now)
+		} else if h.Debug {
+			opLog.Info(fmt.Sprintf("skipping namespace %s; autoidle values are env:%s proj:%s",
+				namespace.Name,
+				environmentAutoIdle,
+				projectAutoIdle))
+		}
+	}), nil
+}
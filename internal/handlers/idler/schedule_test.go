@@ -0,0 +1,185 @@
+package idler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{
+			name:  "test1",
+			value: "awake Mon-Fri 08:00-19:00 Europe/Zurich",
+		},
+		{
+			name:  "test2",
+			value: "awake Mon,Wed,Fri-Sun 22:00-02:00; awake Tue 10:00-24:00",
+		},
+		{
+			name:    "test3",
+			value:   "asleep Mon-Fri 08:00-19:00",
+			wantErr: true,
+		},
+		{
+			name:    "test4",
+			value:   "awake Mon-Fri 08:00-25:00",
+			wantErr: true,
+		},
+		{
+			name:    "test5",
+			value:   "awake Mon-Fri 08:00-19:00 Europe/Nowhere",
+			wantErr: true,
+		},
+		{
+			name:    "test6",
+			value:   "awake Someday 08:00-19:00",
+			wantErr: true,
+		},
+		{
+			name:    "test7",
+			value:   " ; ",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseSchedule(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleAwake(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		time  string
+		want  bool
+	}{
+		{
+			name:  "test1",
+			value: "awake Mon-Fri 08:00-19:00 Europe/Zurich",
+			// wednesday 09:00 in zurich
+			time: "2024-07-03T07:00:00Z",
+			want: true,
+		},
+		{
+			name:  "test2",
+			value: "awake Mon-Fri 08:00-19:00 Europe/Zurich",
+			// wednesday 07:30 in zurich
+			time: "2024-07-03T05:30:00Z",
+			want: false,
+		},
+		{
+			name:  "test3",
+			value: "awake Mon-Fri 08:00-19:00 Europe/Zurich",
+			// saturday 10:00 in zurich
+			time: "2024-07-06T08:00:00Z",
+			want: false,
+		},
+		{
+			name:  "test4",
+			value: "awake Fri 22:00-02:00",
+			// saturday 01:00, inside the window that started on friday
+			time: "2024-07-06T01:00:00Z",
+			want: true,
+		},
+		{
+			name:  "test5",
+			value: "awake Fri 22:00-02:00",
+			// friday 01:00, the window only starts on friday night
+			time: "2024-07-05T01:00:00Z",
+			want: false,
+		},
+		{
+			name:  "test6",
+			value: "awake Mon-Fri 08:00-12:00; awake Sat 10:00-24:00",
+			time:  "2024-07-06T23:59:00Z",
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now, err := time.Parse(time.RFC3339, tt.time)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.awake(now); got != tt.want {
+				t.Errorf("awake() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdler_scheduledIdle(t *testing.T) {
+	tests := []struct {
+		name              string
+		replicas          int32
+		wantDecision      string
+		wantReason        string
+		wantScheduleIdled bool
+	}{
+		{
+			name:              "test1",
+			replicas:          1,
+			wantDecision:      DecisionIdle,
+			wantReason:        PlanReasonSchedule,
+			wantScheduleIdled: true,
+		},
+		{
+			// already idled by the service idler, so it must not be unidled when the next window starts
+			name:         "test2",
+			replicas:     0,
+			wantDecision: DecisionSkip,
+			wantReason:   PlanReasonAlreadyIdled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectors := readTestSelectors(t, "testdata/valid-selectors.yaml")
+			h, namespace := newTestIdler(t, selectors, &StaticHitSource{})
+			ctx := context.Background()
+			deployment := &appsv1.Deployment{}
+			if err := h.Client.Get(ctx, types.NamespacedName{Namespace: namespace.Name, Name: "nginx"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			deployment.Spec.Replicas = &tt.replicas
+			if err := h.Client.Update(ctx, deployment); err != nil {
+				t.Fatal(err)
+			}
+			namespace.Annotations = map[string]string{ScheduleAnnotation: "awake Mon-Fri 08:00-19:00"}
+			// saturday 10:00, outside of the schedule
+			now := time.Date(2024, 7, 6, 10, 0, 0, 0, time.UTC)
+			nsPlan := h.newNamespacePlan(namespace)
+			if !h.scheduledIdle(withNamespacePlan(ctx, nsPlan), logr.Discard(), namespace, "example-com", now) {
+				t.Fatalf("scheduledIdle() = false, want true")
+			}
+			if nsPlan.Decision != tt.wantDecision || nsPlan.Reason != tt.wantReason {
+				t.Errorf("plan = %s, %s, want %s, %s", nsPlan.Decision, nsPlan.Reason, tt.wantDecision, tt.wantReason)
+			}
+			got := &corev1.Namespace{}
+			if err := h.Client.Get(ctx, types.NamespacedName{Name: namespace.Name}, got); err != nil {
+				t.Fatal(err)
+			}
+			if scheduleIdled := got.Labels[ScheduleIdledLabel] == "true"; scheduleIdled != tt.wantScheduleIdled {
+				t.Errorf("%s = %v, want %v", ScheduleIdledLabel, scheduleIdled, tt.wantScheduleIdled)
+			}
+		})
+	}
+}
@@ -6,24 +6,44 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	"github.com/uselagoon/aergia-controller/internal/handlers/notifier"
+	"github.com/uselagoon/aergia-controller/internal/handlers/recorder"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
+	"github.com/uselagoon/aergia-controller/internal/handlers/waves"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	client "sigs.k8s.io/controller-runtime/pkg/client"
-
-	prometheusapiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
-	prometheusmodel "github.com/prometheus/common/model"
 )
 
+// waveScaleDownTimeout is how long to wait, in total, for the waves of deployments to scale down when idling.
+const waveScaleDownTimeout = 90 * time.Second
+
 // KubernetesServiceIdler handles scaling deployments in kubernetes.
-func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, lagoonProject string, forceIdle, forceScale bool) {
-	labelRequirements := generateLabelRequirements(h.Selectors.Service.Builds)
+func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, lagoonProject string, forceIdle, forceScale bool) bool {
+	plan := planFor(ctx)
+	if plan == nil {
+		// the idler runs count the decision for each namespace they check, force idling by the controller doesn't
+		// have a plan so the decision is counted here
+		plan = h.newNamespacePlan(namespace)
+		defer h.recordDecision(ServiceIdlerName, plan)
+	}
+	selectors := h.GetSelectors()
+	labelRequirements, err := generateLabelRequirements(selectors.Service.Builds)
+	if err != nil {
+		opLog.Error(err, "Error generating build selectors")
+		plan.decide(DecisionError, PlanReasonError, "error generating build selectors: %v", err)
+		return false
+	}
 	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 		client.InNamespace(namespace.Name),
 		client.MatchingLabelsSelector{
@@ -32,6 +52,13 @@ func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, n
 	})
 	podIntervalCheck := h.PodCheckInterval
 	prometheusInternalCheck := h.PrometheusCheckInterval
+	// allow policy interval overrides
+	if selectors.Service.PodCheckInterval > 0 {
+		podIntervalCheck = selectors.Service.PodCheckInterval
+	}
+	if selectors.Service.PrometheusCheckInterval > 0 {
+		prometheusInternalCheck = selectors.Service.PrometheusCheckInterval
+	}
 	// allow namespace interval overides
 	if podinterval, ok := namespace.Annotations["idling.amazee.io/pod-interval"]; ok {
 		t, err := time.ParseDuration(podinterval)
@@ -46,9 +73,21 @@ func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, n
 			prometheusInternalCheck = t
 		}
 	}
+	plan.intervals(podIntervalCheck, prometheusInternalCheck)
+	// an environment that was unidled recently isn't idled again until it has been awake for the minimum awake time,
+	// unless it is being forced
+	if !forceIdle && !forceScale {
+		if remaining, minAwake := h.awakeRemaining(namespace, time.Now(), opLog); remaining > 0 {
+			opLog.Info(fmt.Sprintf("Environment was unidled less than %s ago, skipping for another %s", minAwake, remaining.Round(time.Second)))
+			recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeNormal, recorder.ReasonSkippedMinAwake, recorder.ActionSkip,
+				"Environment not idled, it was unidled less than %s ago", minAwake)
+			plan.decide(DecisionSkip, PlanReasonMinAwake, "the environment was unidled less than %s ago", minAwake)
+			return false
+		}
+	}
 	builds := &corev1.PodList{}
 	runningBuild := false
-	if !h.Selectors.Service.SkipBuildCheck {
+	if !selectors.Service.SkipBuildCheck {
 		if err := h.Client.List(ctx, builds, listOption); err != nil {
 			opLog.Error(err, fmt.Sprintf("Error getting running builds for namespace %s", namespace.Name))
 		} else {
@@ -62,9 +101,19 @@ func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, n
 			}
 		}
 	}
+	if runningBuild {
+		recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeNormal, recorder.ReasonSkippedRunningBuild, recorder.ActionSkip,
+			"Environment not idled, a build is running")
+		plan.decide(DecisionSkip, PlanReasonRunningBuild, "a build is running")
+	}
 	// if there are no builds, then check all the deployments that match our labelselectors
 	if !runningBuild {
-		labelRequirements := generateLabelRequirements(h.Selectors.Service.Deployments)
+		labelRequirements, err := generateLabelRequirements(selectors.Service.Deployments)
+		if err != nil {
+			opLog.Error(err, "Error generating deployment selectors")
+			plan.decide(DecisionError, PlanReasonError, "error generating deployment selectors: %v", err)
+			return false
+		}
 		listOption = (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 			client.InNamespace(namespace.Name),
 			client.MatchingLabelsSelector{
@@ -72,28 +121,34 @@ func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, n
 			},
 		})
 		idle := false
+		running := false
 		deployments := &appsv1.DeploymentList{}
 		if err := h.Client.List(ctx, deployments, listOption); err != nil {
 			// if we can't get any deployment configs for this namespace, log it and move on to the next
 			opLog.Error(err, "Error getting deployments")
-			return
+			plan.decide(DecisionError, PlanReasonError, "error getting deployments: %v", err)
+			return false
 		}
 		for _, deployment := range deployments.Items {
 			checkPods := false
-			zeroReps := new(int32)
-			*zeroReps = 0
-			if deployment.Spec.Replicas != zeroReps {
-				opLog.Info(fmt.Sprintf("Deployment %s has %d running replicas", deployment.Name, *deployment.Spec.Replicas))
+			// a deployment without replicas set has the default of 1
+			replicas := int32(1)
+			if deployment.Spec.Replicas != nil {
+				replicas = *deployment.Spec.Replicas
+			}
+			if replicas != 0 {
+				opLog.Info(fmt.Sprintf("Deployment %s has %d running replicas", deployment.Name, replicas))
 				checkPods = true
+				running = true
 			} else if h.Debug {
 				opLog.Info(fmt.Sprintf("Deployment %s already idled", deployment.Name))
 			}
 			if checkPods {
 				pods := &corev1.PodList{}
-				// pods in kubernetes have the label `h.Selectors.ServiceName` with the name of the deployment in it
+				// pods in kubernetes have the label `selectors.ServiceName` with the name of the deployment in it
 				listOption = (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 					client.InNamespace(namespace.Name),
-					client.MatchingLabels(map[string]string{h.Selectors.ServiceName: deployment.Name}),
+					client.MatchingLabels(map[string]string{selectors.ServiceName: deployment.Name}),
 				})
 				if err := h.Client.List(ctx, pods, listOption); err != nil {
 					// if we can't get any pods for this deployment, log it and move on to the next
@@ -118,134 +173,392 @@ func (h *Idler) KubernetesServiceIdler(ctx context.Context, opLog logr.Logger, n
 		// we the idle flag, then proceed to check the router logs and eventually idle the environment
 		if idle || forceIdle || forceScale {
 			numHits := 0
-			if !h.Selectors.Service.SkipHitCheck && !forceIdle && !forceScale {
+			if !selectors.Service.SkipHitCheck && !forceIdle && !forceScale {
 				opLog.Info("Environment marked for idling, checking routerlogs for hits")
-				// query prometheus for hits to ingress resources in this namespace
-				v1api := prometheusapiv1.NewAPI(h.PrometheusClient)
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				defer cancel()
-				// get the number of requests to any ingress in the exported namespace by status code
-				promQuery := fmt.Sprintf(
-					`round(sum(increase(nginx_ingress_controller_requests{exported_namespace="%s",status=~"2[0-9x]{2}"}[%s])) by (status))`,
-					namespace.Name,
-					prometheusInternalCheck,
-				)
-				result, warnings, err := v1api.Query(ctx, promQuery, time.Now())
-				if err != nil {
-					opLog.Error(err, "Error querying Prometheus")
-					return
-				}
-				if len(warnings) > 0 {
-					opLog.Info(fmt.Sprintf("Warnings: %v", warnings))
+				if h.HitSource == nil {
+					opLog.Info("Environment not idled, no hit source is configured")
+					plan.decide(DecisionSkip, PlanReasonNoHitSource, "no hit source is configured")
+					return false
 				}
-				promQuery2 := fmt.Sprintf(
-					`round(sum(increase(traefik_service_requests_total{exported_service=~"%s-.*",code=~"2[0-9x]{2}"}[%s])) by (code))`,
-					namespace.Name,
-					prometheusInternalCheck,
-				)
-				result2, warnings2, err := v1api.Query(ctx, promQuery2, time.Now())
+				// query the hit source for hits to ingress resources in this namespace
+				hitsCtx, hitsSpan := tracing.Start(ctx, "Hits")
+				hits, err := h.HitSource.Hits(hitsCtx, opLog, namespace, prometheusInternalCheck)
+				tracing.End(hitsSpan, err)
 				if err != nil {
-					opLog.Error(err, "Error querying Prometheus")
-					return
-				}
-				if len(warnings2) > 0 {
-					opLog.Info(fmt.Sprintf("Warnings: %v", warnings2))
-				}
-				// and then add up the results of all the status requests to determine hit count
-				if result.Type() == prometheusmodel.ValVector {
-					resultVal := result.(prometheusmodel.Vector)
-					for _, elem := range resultVal {
-						hits, _ := strconv.Atoi(elem.Value.String())
-						numHits += hits
-					}
-				}
-				if result2.Type() == prometheusmodel.ValVector {
-					resultVal := result2.(prometheusmodel.Vector)
-					for _, elem := range resultVal {
-						hits, _ := strconv.Atoi(elem.Value.String())
-						numHits += hits
-					}
+					opLog.Error(err, "Error getting hits")
+					plan.decide(DecisionError, PlanReasonHitsError, "error getting hits: %v", err)
+					return false
 				}
+				numHits = hits
+				plan.hits(numHits)
 				// if the hits are not 0, then the environment doesn't need to be idled
 				opLog.Info(fmt.Sprintf("Environment has had %d hits in the last %s", numHits, prometheusInternalCheck))
 				if numHits != 0 {
 					opLog.Info("Environment does not need idling")
-					return
+					recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeNormal, recorder.ReasonSkippedHits, recorder.ActionSkip,
+						"Environment not idled, it has had %d hits in the last %s", numHits, prometheusInternalCheck)
+					plan.decide(DecisionSkip, PlanReasonHits, "it has had %d hits in the last %s", numHits, prometheusInternalCheck)
+					return false
 				}
 			}
 			// if there weren't any issues patching the ingress, then proceed to scale the deployments
 			// just disregard the error, we're logging it in the patchIngres function, but if that step fails
 			// the environment shouldn't be idled, as it will never unidle if the ingress annotation doesn't exist
-			err := h.patchIngress(ctx, opLog, namespace)
+			err := h.patchIngress(ctx, opLog, namespace, forceScale)
 			if err != nil {
 				// if patching the ingress resources fail, then don't idle the environment
 				opLog.Info("Environment not idled due to errors patching ingress")
-				return
+				recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeWarning, recorder.ReasonIdlingFailed, recorder.ActionIdle,
+					"Environment not idled, %v", err)
+				plan.decide(DecisionError, PlanReasonIngressFailure, "%v", err)
+				return false
 			}
 			opLog.Info("Environment will be idled")
-			h.idleDeployments(ctx, opLog, deployments, forceIdle, forceScale)
+			planReason, planMessage := planIdleReason(forceIdle, forceScale, selectors.Service.SkipHitCheck, numHits, podIntervalCheck, prometheusInternalCheck)
+			plan.decide(DecisionIdle, planReason, "%s", planMessage)
+			if !h.DryRun {
+				reason, note := idleEventNote(forceIdle, forceScale, selectors.Service.SkipHitCheck, numHits, podIntervalCheck, prometheusInternalCheck)
+				recorder.Namespace(h.Recorder, &namespace, corev1.EventTypeNormal, reason, recorder.ActionIdle, note)
+			}
+			// suspend any cronjobs first so they don't start any pods while the environment is idled
+			h.idleCronJobs(ctx, opLog, namespace, selectors, forceIdle, forceScale)
+			// the web deployments are idled before any statefulsets they may depend on
+			h.idleDeployments(ctx, opLog, namespace, deployments, forceIdle, forceScale)
+			h.idleStatefulSets(ctx, opLog, namespace, selectors, forceIdle, forceScale)
+			return true
+		}
+		if !running && len(deployments.Items) > 0 {
+			plan.decide(DecisionSkip, PlanReasonAlreadyIdled, "the deployments are already idled")
+		} else {
+			plan.decide(DecisionSkip, PlanReasonPodAge, "no pods have been running for more than %s", podIntervalCheck)
+		}
+	}
+	return false
+}
+
+/*
+idleDeployments will scale the deployments to zero, in the reverse of the waves they are unidled in.
+each wave is scaled down before the next one, so deployments are stopped before anything they depend on.
+the waves share one deadline for scaling down, so that idling the namespace stays within the namespace timeout.
+*/
+func (h *Idler) idleDeployments(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, deployments *appsv1.DeploymentList, forceIdle, forceScale bool) {
+	idleWaves, err := waves.Order(deployments.Items)
+	if err != nil {
+		opLog.Info(fmt.Sprintf("Unable to order deployments into waves, idling them together: %v", err))
+		idleWaves = [][]appsv1.Deployment{deployments.Items}
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, waveWaitTimeout(ctx))
+	defer cancel()
+	scaled := []string{}
+	for i := len(idleWaves) - 1; i >= 0; i-- {
+		wave := idleWaves[i]
+		if len(idleWaves) > 1 {
+			opLog.Info(fmt.Sprintf("Idling wave %d of %d: %s", i+1, len(idleWaves), strings.Join(waves.Names(wave), ", ")))
+		}
+		waveScaled := []string{}
+		for _, deployment := range wave {
+			// @TODO: use the patch method for the k8s client for now, this seems to work just fine
+			// Patching the deployment also works as we patch the endpoints below
+			if !h.DryRun {
+				scaleDeployment := deployment.DeepCopy()
+				mergePatch := idlePatch(deployment.Spec.Replicas, forceIdle, forceScale)
+				if err := h.Client.Patch(ctx, scaleDeployment, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+					// log it but try and scale the rest of the deployments anyway (some idled is better than none?)
+					opLog.Info(fmt.Sprintf("Error scaling deployment %s", deployment.Name))
+				} else {
+					opLog.Info(fmt.Sprintf("Deployment %s scaled to 0", deployment.Name))
+					recorder.Object(h.Recorder, &deployment, corev1.EventTypeNormal, idleReason(forceIdle, forceScale), recorder.ActionIdle,
+						"Scaled to 0 replicas, %d replicas will be restored when unidled", idleReplicas(deployment.Spec.Replicas))
+					waveScaled = append(waveScaled, deployment.Name)
+					planFor(ctx).touch(planDeployment, deployment.Name)
+				}
+			} else {
+				opLog.Info(fmt.Sprintf("Deployment %s would be scaled to 0", deployment.Name))
+				planFor(ctx).touch(planDeployment, deployment.Name)
+			}
+		}
+		// wait for the pods of this wave to stop before scaling down the wave it depends on, once the deadline has
+		// passed the rest of the waves are scaled down without waiting
+		if i > 0 && len(waveScaled) > 0 && waitCtx.Err() == nil {
+			err := wait.PollUntilContextCancel(waitCtx, time.Second, true, h.hasScaledDown(namespace.Name, waveScaled))
+			if err != nil {
+				opLog.Info(fmt.Sprintf("Deployments %s not scaled down, continuing: %v", strings.Join(waveScaled, ", "), err))
+			}
+		}
+		scaled = append(scaled, waveScaled...)
+	}
+	if len(scaled) > 0 {
+		h.Notifier.Publish(ctx, notifyEvent(forceIdle, forceScale), &namespace,
+			fmt.Sprintf("Deployments %s scaled to 0", strings.Join(scaled, ", ")))
+	}
+}
+
+// waveWaitTimeout returns how long idling waits for the waves to scale down. If the namespace has a timeout, only half of
+// the time left is used, so that there is still time to idle the statefulsets.
+func waveWaitTimeout(ctx context.Context) time.Duration {
+	timeout := waveScaleDownTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline) / 2; remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
+// hasScaledDown returns a condition that is true once all the deployments have no replicas left.
+func (h *Idler) hasScaledDown(namespace string, names []string) wait.ConditionWithContextFunc {
+	return func(ctx context.Context) (bool, error) {
+		for _, name := range names {
+			deployment := &appsv1.Deployment{}
+			if err := h.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, deployment); err != nil {
+				return false, err
+			}
+			if deployment.Status.Replicas > 0 {
+				return false, nil
+			}
 		}
+		return true, nil
 	}
 }
 
-func (h *Idler) idleDeployments(ctx context.Context, opLog logr.Logger, deployments *appsv1.DeploymentList, forceIdle, forceScale bool) {
-	for _, deployment := range deployments.Items {
-		// @TODO: use the patch method for the k8s client for now, this seems to work just fine
-		// Patching the deployment also works as we patch the endpoints below
+/*
+idleStatefulSets will scale any statefulsets matching the statefulset selectors to zero.
+if there are no statefulset selectors defined, then no statefulsets are idled.
+*/
+func (h *Idler) idleStatefulSets(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, selectors *Data, forceIdle, forceScale bool) {
+	if len(selectors.Service.StatefulSets) == 0 {
+		return
+	}
+	labelRequirements, err := generateLabelRequirements(selectors.Service.StatefulSets)
+	if err != nil {
+		opLog.Error(err, "Error generating statefulset selectors")
+		return
+	}
+	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
+		client.InNamespace(namespace.Name),
+		client.MatchingLabelsSelector{
+			Selector: labels.NewSelector().Add(labelRequirements...),
+		},
+	})
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := h.Client.List(ctx, statefulSets, listOption); err != nil {
+		opLog.Error(err, "Error getting statefulsets")
+		return
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if statefulSet.Spec.Replicas != nil && *statefulSet.Spec.Replicas == 0 {
+			if h.Debug {
+				opLog.Info(fmt.Sprintf("StatefulSet %s already idled", statefulSet.Name))
+			}
+			continue
+		}
 		if !h.DryRun {
-			// to avoid having the idle replicas as 0, always use 1
-			// this is to help prevent a deployment from incorrectly being told to have 0 replicas
-			idleReplicas := new(int32)
-			*idleReplicas = 1
-			if *deployment.Spec.Replicas > 0 {
-				// and override it with whatever is in the deployment if it is greater than 0
-				idleReplicas = deployment.Spec.Replicas
+			scaleStatefulSet := statefulSet.DeepCopy()
+			mergePatch := idlePatch(statefulSet.Spec.Replicas, forceIdle, forceScale)
+			if err := h.Client.Patch(ctx, scaleStatefulSet, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+				opLog.Info(fmt.Sprintf("Error scaling statefulset %s", statefulSet.Name))
+			} else {
+				opLog.Info(fmt.Sprintf("StatefulSet %s scaled to 0", statefulSet.Name))
+				recorder.Object(h.Recorder, &statefulSet, corev1.EventTypeNormal, idleReason(forceIdle, forceScale), recorder.ActionIdle,
+					"Scaled to 0 replicas, %d replicas will be restored when unidled", idleReplicas(statefulSet.Spec.Replicas))
+				planFor(ctx).touch(planStatefulSet, statefulSet.Name)
+			}
+		} else {
+			opLog.Info(fmt.Sprintf("StatefulSet %s would be scaled to 0", statefulSet.Name))
+			planFor(ctx).touch(planStatefulSet, statefulSet.Name)
+		}
+	}
+}
+
+/*
+idleCronJobs will suspend any cronjobs matching the cronjob selectors, the original suspend state is stored in an
+annotation so the unidler can restore it. if there are no cronjob selectors defined, then no cronjobs are suspended.
+*/
+func (h *Idler) idleCronJobs(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, selectors *Data, forceIdle, forceScale bool) {
+	if len(selectors.Service.CronJobs) == 0 {
+		return
+	}
+	labelRequirements, err := generateLabelRequirements(selectors.Service.CronJobs)
+	if err != nil {
+		opLog.Error(err, "Error generating cronjob selectors")
+		return
+	}
+	listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
+		client.InNamespace(namespace.Name),
+		client.MatchingLabelsSelector{
+			Selector: labels.NewSelector().Add(labelRequirements...),
+		},
+	})
+	cronJobs := &batchv1.CronJobList{}
+	if err := h.Client.List(ctx, cronJobs, listOption); err != nil {
+		opLog.Error(err, "Error getting cronjobs")
+		return
+	}
+	for _, cronJob := range cronJobs.Items {
+		if cronJob.Labels["idling.amazee.io/idled"] == "true" {
+			// already suspended by aergia, don't overwrite the original suspend state
+			// but make sure a force scale is recorded so that the cronjob stays suspended
+			if forceScale && cronJob.Labels["idling.amazee.io/force-scaled"] != "true" && !h.DryRun {
+				mergePatch, _ := json.Marshal(map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]string{
+							"idling.amazee.io/force-scaled": "true",
+						},
+					},
+				})
+				if err := h.Client.Patch(ctx, cronJob.DeepCopy(), client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+					opLog.Info(fmt.Sprintf("Error labelling cronjob %s", cronJob.Name))
+				}
+			} else if h.Debug {
+				opLog.Info(fmt.Sprintf("CronJob %s already suspended", cronJob.Name))
 			}
-			scaleDeployment := deployment.DeepCopy()
-			labels := map[string]string{
-				// add the watch label so that the unidler knows to look at it
+			continue
+		}
+		if !h.DryRun {
+			suspended := cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend
+			cronJobLabels := map[string]string{
 				"idling.amazee.io/watch": "true",
 				"idling.amazee.io/idled": "true",
 			}
 			if forceIdle {
-				labels["idling.amazee.io/force-idled"] = "true"
+				cronJobLabels["idling.amazee.io/force-idled"] = "true"
 			}
 			if forceScale {
-				labels["idling.amazee.io/force-scaled"] = "true"
+				cronJobLabels["idling.amazee.io/force-scaled"] = "true"
 			}
 			mergePatch, _ := json.Marshal(map[string]interface{}{
 				"spec": map[string]interface{}{
-					"replicas": 0,
+					"suspend": true,
 				},
 				"metadata": map[string]interface{}{
-					"labels": labels,
+					"labels": cronJobLabels,
 					"annotations": map[string]string{
-						// add these annotations so user knows to look at them
-						"idling.amazee.io/idled-at":        time.Now().Format(time.RFC3339),
-						"idling.amazee.io/unidle-replicas": strconv.FormatInt(int64(*idleReplicas), 10),
+						"idling.amazee.io/idled-at":       time.Now().Format(time.RFC3339),
+						"idling.amazee.io/unidle-suspend": strconv.FormatBool(suspended),
 					},
 				},
 			})
-			if err := h.Client.Patch(ctx, scaleDeployment, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
-				// log it but try and scale the rest of the deployments anyway (some idled is better than none?)
-				opLog.Info(fmt.Sprintf("Error scaling deployment %s", deployment.Name))
+			suspendCronJob := cronJob.DeepCopy()
+			if err := h.Client.Patch(ctx, suspendCronJob, client.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+				opLog.Info(fmt.Sprintf("Error suspending cronjob %s", cronJob.Name))
 			} else {
-				opLog.Info(fmt.Sprintf("Deployment %s scaled to 0", deployment.Name))
+				opLog.Info(fmt.Sprintf("CronJob %s suspended", cronJob.Name))
+				planFor(ctx).touch(planCronJob, cronJob.Name)
 			}
 		} else {
-			opLog.Info(fmt.Sprintf("Deployment %s would be scaled to 0", deployment.Name))
+			opLog.Info(fmt.Sprintf("CronJob %s would be suspended", cronJob.Name))
+			planFor(ctx).touch(planCronJob, cronJob.Name)
 		}
 	}
 }
 
+// idleReplicas returns the number of replicas to restore when the deployment or statefulset is unidled.
+func idleReplicas(replicas *int32) int32 {
+	// to avoid having the idle replicas as 0, always use 1
+	// this is to help prevent a deployment from incorrectly being told to have 0 replicas
+	if replicas != nil && *replicas > 0 {
+		// and override it with whatever is in the deployment if it is greater than 0
+		return *replicas
+	}
+	return 1
+}
+
+// idleReason returns the reason for the events recorded when an environment is idled.
+func idleReason(forceIdle, forceScale bool) string {
+	switch {
+	case forceScale:
+		return recorder.ReasonForceScaled
+	case forceIdle:
+		return recorder.ReasonForceIdled
+	}
+	return recorder.ReasonIdled
+}
+
+// notifyEvent returns the notifier event type for the way an environment was idled.
+func notifyEvent(forceIdle, forceScale bool) notifier.EventType {
+	switch {
+	case forceScale:
+		return notifier.EventForceScaled
+	case forceIdle:
+		return notifier.EventForceIdled
+	}
+	return notifier.EventIdled
+}
+
+// planIdleReason returns the reason and message recorded in the plan for idling an environment.
+func planIdleReason(forceIdle, forceScale, skipHitCheck bool, numHits int, podInterval, hitInterval time.Duration) (string, string) {
+	switch {
+	case forceScale:
+		return PlanReasonForced, "the environment is force scaled"
+	case forceIdle:
+		return PlanReasonForced, "the environment is force idled"
+	case skipHitCheck:
+		return PlanReasonSkipHitCheck, fmt.Sprintf("pods have been running for more than %s", podInterval)
+	}
+	return PlanReasonNoHits, fmt.Sprintf("pods have been running for more than %s and it has had %d hits in the last %s",
+		podInterval, numHits, hitInterval)
+}
+
+// idleEventNote returns the reason and note for the event recorded on the namespace when an environment is idled.
+func idleEventNote(forceIdle, forceScale, skipHitCheck bool, numHits int, podInterval, hitInterval time.Duration) (string, string) {
+	reason := idleReason(forceIdle, forceScale)
+	switch {
+	case forceScale:
+		return reason, "Environment force scaled, it will not be unidled by a request"
+	case forceIdle:
+		return reason, "Environment force idled, it will be unidled by the next request"
+	case skipHitCheck:
+		return reason, fmt.Sprintf("Environment idled, pods have been running for more than %s", podInterval)
+	}
+	return reason, fmt.Sprintf("Environment idled, pods have been running for more than %s and it has had %d hits in the last %s",
+		podInterval, numHits, hitInterval)
+}
+
+// idlePatch returns the merge patch used to scale a deployment or statefulset to zero.
+func idlePatch(replicas *int32, forceIdle, forceScale bool) []byte {
+	unidleReplicas := idleReplicas(replicas)
+	labels := map[string]string{
+		// add the watch label so that the unidler knows to look at it
+		"idling.amazee.io/watch": "true",
+		"idling.amazee.io/idled": "true",
+	}
+	if forceIdle {
+		labels["idling.amazee.io/force-idled"] = "true"
+	}
+	if forceScale {
+		labels["idling.amazee.io/force-scaled"] = "true"
+	}
+	mergePatch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": 0,
+		},
+		"metadata": map[string]interface{}{
+			"labels": labels,
+			"annotations": map[string]string{
+				// add these annotations so user knows to look at them
+				"idling.amazee.io/idled-at":        time.Now().Format(time.RFC3339),
+				"idling.amazee.io/unidle-replicas": strconv.FormatInt(int64(unidleReplicas), 10),
+			},
+		},
+	})
+	return mergePatch
+}
+
 /*
 patchIngress will patch any ingress with matching labels with the `custom-http-errors` annotation.
 this annotation is used by the unidler to make sure that the correct information is passed to the custom backend for
-the nginx ingress controller so that we can handle unidling of the environment properly
+the nginx ingress controller so that we can handle unidling of the environment properly.
+any matching HTTPRoutes are also redirected to the custom backend.
+the namespace is annotated with when it was idled, and if it was force scaled, in the same patch that labels it as idled.
 */
-func (h *Idler) patchIngress(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace) error {
-	if !h.Selectors.Service.SkipIngressPatch {
-		labelRequirements := generateLabelRequirements(h.Selectors.Service.Ingress)
+func (h *Idler) patchIngress(ctx context.Context, opLog logr.Logger, namespace corev1.Namespace, forceScale bool) error {
+	selectors := h.GetSelectors()
+	if !selectors.Service.SkipIngressPatch {
+		labelRequirements, err := generateLabelRequirements(selectors.Service.Ingress)
+		if err != nil {
+			opLog.Error(err, "Error generating ingress selectors")
+			return fmt.Errorf("error generating ingress selectors")
+		}
 		listOption := (&client.ListOptions{}).ApplyOptions([]client.ListOption{
 			client.InNamespace(namespace.Name),
 			client.MatchingLabelsSelector{
@@ -291,19 +604,36 @@ func (h *Idler) patchIngress(ctx context.Context, opLog logr.Logger, namespace c
 					return fmt.Errorf("error patching ingress %s", ingress.Name)
 				}
 				opLog.Info(fmt.Sprintf("Ingress %s patched", ingress.Name))
+				recorder.Object(h.Recorder, &ingress, corev1.EventTypeNormal, recorder.ReasonIdled, recorder.ActionIdle,
+					"Requests to this ingress will be served by aergia until the environment is unidled")
+				planFor(ctx).touch(planIngress, ingress.Name)
 				patched = true
 			} else {
 				opLog.Info(fmt.Sprintf("Ingress %s would be patched", ingress.Name))
+				planFor(ctx).touch(planIngress, ingress.Name)
 			}
 		}
-		if patched {
-			// update the namespace to indicate it is idled
+		routesPatched, err := h.patchHTTPRoutes(ctx, opLog, namespace, selectors)
+		if err != nil {
+			return err
+		}
+		if patched || routesPatched {
+			// update the namespace to indicate it is idled, the deployments may not have been scaled yet when the
+			// namespace is reconciled, so the state metrics are taken from these annotations
+			annotations := map[string]interface{}{
+				"idling.amazee.io/idled-at":     time.Now().Format(time.RFC3339),
+				"idling.amazee.io/force-scaled": nil,
+			}
+			if forceScale {
+				annotations["idling.amazee.io/force-scaled"] = "true"
+			}
 			namespaceCopy := namespace.DeepCopy()
 			mergePatch, _ := json.Marshal(map[string]interface{}{
 				"metadata": map[string]interface{}{
 					"labels": map[string]string{
 						"idling.amazee.io/idled": "true",
 					},
+					"annotations": annotations,
 				},
 			})
 			metrics.ServiceIdleEvents.Inc()
@@ -0,0 +1,105 @@
+package idler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/waves"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWaveWaitTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{
+			name: "test1",
+			want: waveScaleDownTimeout,
+		},
+		{
+			name:    "test2",
+			timeout: 5 * time.Minute,
+			want:    waveScaleDownTimeout,
+		},
+		{
+			name:    "test3",
+			timeout: time.Minute,
+			want:    30 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			// allow for the time taken since the deadline was set
+			if got := waveWaitTimeout(ctx); got > tt.want || got < tt.want-time.Second {
+				t.Errorf("waveWaitTimeout() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdler_idleDeploymentsWaves(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespace := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}}
+	deployments := &appsv1.DeploymentList{}
+	objects := []client.Object{}
+	for i := range 3 {
+		replicas := int32(2)
+		deployment := appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        fmt.Sprintf("wave-%d", i),
+				Namespace:   namespace.Name,
+				Annotations: map[string]string{waves.WaveAnnotation: fmt.Sprint(i)},
+			},
+			Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+			// the fake client never scales the pods down, so every wave waits until the deadline
+			Status: appsv1.DeploymentStatus{Replicas: 2},
+		}
+		deployments.Items = append(deployments.Items, deployment)
+		objects = append(objects, deployment.DeepCopy())
+	}
+	h := &Idler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:    logr.Discard(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	start := time.Now()
+	h.idleDeployments(ctx, logr.Discard(), namespace, deployments, false, false)
+	// the waves share half of the time left, instead of each wave waiting for its own timeout
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("idleDeployments() took %s, want less than 3s", elapsed)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("idleDeployments() used up the namespace timeout")
+	}
+	for _, deployment := range deployments.Items {
+		scaled := &appsv1.Deployment{}
+		if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: namespace.Name, Name: deployment.Name}, scaled); err != nil {
+			t.Fatal(err)
+		}
+		if *scaled.Spec.Replicas != 0 {
+			t.Errorf("deployment %s replicas = %d, want 0", deployment.Name, *scaled.Spec.Replicas)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package idler
 import (
 	"context"
 	"fmt"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -14,17 +15,26 @@ import (
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=list;get;watch;patch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list;get;watch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=list;get;watch;patch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=list;get;watch;patch
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=list;get;watch;patch
 // +kubebuilder:rbac:groups=*,resources=ingresses,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=*,resources=ingress/status,verbs=get;update;patch
 
 // ServiceIdler will run the Service idler process.
-func (h *Idler) ServiceIdler() {
-	ctx := context.Background()
+func (h *Idler) ServiceIdler(ctx context.Context) {
+	h.runIdler(ctx, ServiceIdlerName, h.Log, h.serviceIdler)
+}
 
+func (h *Idler) serviceIdler(ctx context.Context) (int, error) {
 	opLog := h.Log
+	selectors := h.GetSelectors()
 	// in kubernetes, we can reliably check for the existence of this label so that
 	// we only check namespaces that have been deployed by a lagoon at one point
-	labelRequirements := generateLabelRequirements(h.Selectors.Service.Namespace)
+	labelRequirements, err := generateLabelRequirements(selectors.Service.Namespace)
+	if err != nil {
+		opLog.Error(err, "unable to generate namespace selectors")
+		return 0, err
+	}
 	// only evaluate namespaces that are not idled
 	// @TODO: reintroduce this later on, since there are some cases where an environment is unidled where this
 	// does not get changed currently
@@ -45,22 +55,30 @@ func (h *Idler) ServiceIdler() {
 	namespaces := &corev1.NamespaceList{}
 	if err := h.Client.List(ctx, namespaces, listOption); err != nil {
 		opLog.Info(fmt.Sprintf("unable to get any namespaces: %v", err))
-		return
+		return 0, err
 	}
-	// loop over the namespaces
-	for _, namespace := range namespaces.Items {
-
-		projectAutoIdle, ok1 := namespace.Labels[h.Selectors.NamespaceSelectorsLabels.ProjectIdling]
-		environmentAutoIdle, ok2 := namespace.Labels[h.Selectors.NamespaceSelectorsLabels.EnvironmentIdling]
-		environmentType, ok3 := namespace.Labels[h.Selectors.NamespaceSelectorsLabels.EnvironmentType]
+	// check the namespaces with the worker pool
+	return h.forEachNamespace(ctx, ServiceIdlerName, opLog, namespaces.Items, func(ctx context.Context, namespace corev1.Namespace) {
+		projectAutoIdle, ok1 := namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectIdling]
+		environmentAutoIdle, ok2 := namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentIdling]
+		environmentType, ok3 := namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentType]
 		if ok1 && ok2 && ok3 {
 			if environmentAutoIdle == "1" && projectAutoIdle == "1" {
 				envOpLog := opLog.WithValues("namespace", namespace.Name).
-					WithValues("project", namespace.Labels[h.Selectors.NamespaceSelectorsLabels.ProjectName]).
-					WithValues("environment", namespace.Labels[h.Selectors.NamespaceSelectorsLabels.EnvironmentName]).
+					WithValues("project", namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName]).
+					WithValues("environment", namespace.Labels[selectors.NamespaceSelectorsLabels.EnvironmentName]).
 					WithValues("dry-run", h.DryRun)
 				envOpLog.Info("Checking namespace")
-				h.KubernetesServiceIdler(ctx, envOpLog, namespace, namespace.Labels[h.Selectors.NamespaceSelectorsLabels.ProjectName], false, false)
+				now := time.Now()
+				if h.scheduledIdle(ctx, envOpLog, namespace, namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName], now) {
+					return
+				}
+				if freeze, ok := h.frozen(namespace, now); ok {
+					envOpLog.Info(fmt.Sprintf("Environment not idled, %s", freeze))
+					planFor(ctx).decide(DecisionSkip, PlanReasonFrozen, "%s", freeze)
+					return
+				}
+				h.KubernetesServiceIdler(ctx, envOpLog, namespace, namespace.Labels[selectors.NamespaceSelectorsLabels.ProjectName], false, false)
 			} else if h.Debug {
 				opLog.Info(fmt.Sprintf("skipping namespace %s; type is %s, autoidle values are env:%s proj:%s",
 					namespace.Name,
@@ -74,5 +92,5 @@ func (h *Idler) ServiceIdler() {
 					namespace.Name))
 			}
 		}
-	}
+	}), nil
 }
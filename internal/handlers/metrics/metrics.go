@@ -23,6 +23,19 @@ func init() {
 		UnidleEvents,
 		ServiceIdleEvents,
 		CliIdleEvents,
+		ConfigReloads,
+		ThrottledRequests,
+		IdlerRuns,
+		IdlerRunDuration,
+		IdlerNamespaceTimeouts,
+		UnidleDuration,
+		UnidleFailures,
+		NamespaceStates,
+		IdlerDecisions,
+		IdlerNamespacesEvaluated,
+		IdlerLastRunDuration,
+		IdlerLastSuccess,
+		IdlingFreezeActive,
 	)
 }
 
@@ -39,7 +52,7 @@ var (
 		Subsystem: subsystem,
 		Name:      "request_duration_milliseconds",
 		Help:      "Histogram of the time (in milliseconds) each request took.",
-		Buckets:   append([]float64{.001, .003}, prometheus.DefBuckets...),
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 15),
 	}, []string{"proto"})
 	AllowedRequests = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "aergia_allowed_requests",
@@ -73,4 +86,54 @@ var (
 		Name: "aergia_cli_idling_events",
 		Help: "The total number of cli idling events that aergia has processed to idle environments",
 	})
+	ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aergia_config_reloads",
+		Help: "The total number of times aergia has reloaded a config file, by config and result",
+	}, []string{"config", "result"})
+	ThrottledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aergia_throttled_requests",
+		Help: "The total number of unidle requests that aergia has throttled, by the limit that throttled them",
+	}, []string{"reason"})
+	IdlerRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aergia_idler_runs",
+		Help: "The total number of idler runs, by idler and outcome",
+	}, []string{"idler", "outcome"})
+	IdlerRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aergia_idler_run_duration_seconds",
+		Help:    "Histogram of the time (in seconds) each idler run took, by idler and outcome",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"idler", "outcome"})
+	IdlerNamespaceTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aergia_idler_namespace_timeouts",
+		Help: "The total number of namespaces that took longer than the namespace timeout to check, by idler",
+	}, []string{"idler"})
+	IdlerDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aergia_idler_decisions",
+		Help: "The total number of decisions made by the idlers for each namespace, by idler, decision and reason",
+	}, []string{"idler", "decision", "reason"})
+	IdlerNamespacesEvaluated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aergia_idler_namespaces_evaluated",
+		Help: "The number of namespaces evaluated by the last run of each idler",
+	}, []string{"idler"})
+	IdlerLastRunDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aergia_idler_last_run_duration_seconds",
+		Help: "How long (in seconds) the last run of each idler took",
+	}, []string{"idler"})
+	IdlerLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aergia_idler_last_success_timestamp_seconds",
+		Help: "The unix time the last successful run of each idler finished",
+	}, []string{"idler"})
+	UnidleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aergia_unidle_duration_seconds",
+		Help:    "Histogram of the time (in seconds) each unidle took until the environment was ready, by outcome",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	}, []string{"outcome"})
+	UnidleFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aergia_unidle_failures",
+		Help: "The total number of unidles that timed out waiting for the environment or failed to scale it, by namespace and reason",
+	}, []string{"namespace", "reason"})
+	IdlingFreezeActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aergia_idling_freeze_active",
+		Help: "If an idling freeze is stopping the idlers (1) or not (0), by freeze",
+	}, []string{"freeze"})
 )
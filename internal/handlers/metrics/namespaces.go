@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NamespaceState is the idle state of a namespace.
+type NamespaceState struct {
+	Project     string
+	Idled       bool
+	ForceScaled bool
+	// IdledAt is when the namespace was idled, it is zero if it isn't idled or the time isn't known.
+	IdledAt time.Time
+}
+
+var (
+	namespaceIdledDesc = prometheus.NewDesc("aergia_namespace_idled",
+		"If a namespace is idled (1) or not (0), by namespace and project", []string{"namespace", "project"}, nil)
+	namespaceForceScaledDesc = prometheus.NewDesc("aergia_namespace_force_scaled",
+		"If a namespace is force scaled (1) or not (0), by namespace and project", []string{"namespace", "project"}, nil)
+	namespaceIdledAgeDesc = prometheus.NewDesc("aergia_namespace_idled_age_seconds",
+		"How long (in seconds) an idled namespace has been idled, by namespace and project", []string{"namespace", "project"}, nil)
+)
+
+// NamespaceStates collects the idle state of each namespace, the age is worked out when the metrics are scraped.
+var NamespaceStates = &namespaceStates{states: map[string]NamespaceState{}, now: time.Now}
+
+type namespaceStates struct {
+	lock   sync.RWMutex
+	states map[string]NamespaceState
+	now    func() time.Time
+}
+
+// Set replaces the state of a namespace.
+func (c *namespaceStates) Set(namespace string, state NamespaceState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.states[namespace] = state
+}
+
+// Delete removes a namespace, so that it is no longer collected.
+func (c *namespaceStates) Delete(namespace string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.states, namespace)
+}
+
+// Describe implements prometheus.Collector.
+func (c *namespaceStates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- namespaceIdledDesc
+	ch <- namespaceForceScaledDesc
+	ch <- namespaceIdledAgeDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *namespaceStates) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := c.now()
+	for namespace, state := range c.states {
+		ch <- prometheus.MustNewConstMetric(namespaceIdledDesc, prometheus.GaugeValue, boolValue(state.Idled), namespace, state.Project)
+		ch <- prometheus.MustNewConstMetric(namespaceForceScaledDesc, prometheus.GaugeValue, boolValue(state.ForceScaled), namespace, state.Project)
+		if state.Idled && !state.IdledAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(namespaceIdledAgeDesc, prometheus.GaugeValue, now.Sub(state.IdledAt).Seconds(), namespace, state.Project)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNamespaceStates(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		states map[string]NamespaceState
+		delete []string
+		want   string
+	}{
+		{
+			name: "test1",
+			states: map[string]NamespaceState{
+				"example-com-main": {Project: "example-com", Idled: true, IdledAt: now.Add(-90 * time.Second)},
+			},
+			want: `
+# HELP aergia_namespace_force_scaled If a namespace is force scaled (1) or not (0), by namespace and project
+# TYPE aergia_namespace_force_scaled gauge
+aergia_namespace_force_scaled{namespace="example-com-main",project="example-com"} 0
+# HELP aergia_namespace_idled If a namespace is idled (1) or not (0), by namespace and project
+# TYPE aergia_namespace_idled gauge
+aergia_namespace_idled{namespace="example-com-main",project="example-com"} 1
+# HELP aergia_namespace_idled_age_seconds How long (in seconds) an idled namespace has been idled, by namespace and project
+# TYPE aergia_namespace_idled_age_seconds gauge
+aergia_namespace_idled_age_seconds{namespace="example-com-main",project="example-com"} 90
+`,
+		},
+		{
+			name: "test2",
+			states: map[string]NamespaceState{
+				"example-com-main": {Project: "example-com", IdledAt: now.Add(-90 * time.Second)},
+				"example-com-dev":  {Project: "example-com", Idled: true, ForceScaled: true},
+			},
+			want: `
+# HELP aergia_namespace_force_scaled If a namespace is force scaled (1) or not (0), by namespace and project
+# TYPE aergia_namespace_force_scaled gauge
+aergia_namespace_force_scaled{namespace="example-com-dev",project="example-com"} 1
+aergia_namespace_force_scaled{namespace="example-com-main",project="example-com"} 0
+# HELP aergia_namespace_idled If a namespace is idled (1) or not (0), by namespace and project
+# TYPE aergia_namespace_idled gauge
+aergia_namespace_idled{namespace="example-com-dev",project="example-com"} 1
+aergia_namespace_idled{namespace="example-com-main",project="example-com"} 0
+`,
+		},
+		{
+			name: "test3",
+			states: map[string]NamespaceState{
+				"example-com-main": {Project: "example-com", Idled: true},
+			},
+			delete: []string{"example-com-main"},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &namespaceStates{states: map[string]NamespaceState{}, now: func() time.Time { return now }}
+			for namespace, state := range tt.states {
+				c.Set(namespace, state)
+			}
+			for _, namespace := range tt.delete {
+				c.Delete(namespace)
+			}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
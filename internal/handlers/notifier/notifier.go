@@ -0,0 +1,265 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/go-logr/logr"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EventType is the type of event sent to the endpoints.
+type EventType string
+
+const (
+	EventIdled       EventType = "idled"
+	EventUnidled     EventType = "unidled"
+	EventForceIdled  EventType = "force-idled"
+	EventForceScaled EventType = "force-scaled"
+)
+
+const (
+	// SignatureHeader contains the hex encoded HMAC-SHA256 of the request body, if the endpoint has a secret.
+	SignatureHeader = "X-Aergia-Signature"
+	// EventHeader contains the type of the event.
+	EventHeader = "X-Aergia-Event"
+
+	defaultRetries = 3
+	defaultBackoff = 1 * time.Second
+	defaultTimeout = 10 * time.Second
+)
+
+// Endpoint is an http endpoint that events are sent to.
+type Endpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret is used to sign the request body, if empty the request is not signed.
+	Secret string `json:"secret,omitempty"`
+	// Events is the list of event types to send to the endpoint, if empty all events are sent.
+	Events  []EventType       `json:"events,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template replaces the default JSON payload, the fields of the Payload are available to the template.
+	Template string `json:"template,omitempty"`
+	tmpl     *template.Template
+}
+
+// Payload is the body sent to the endpoints.
+type Payload struct {
+	Type        EventType `json:"type"`
+	Namespace   string    `json:"namespace"`
+	Project     string    `json:"project,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+}
+
+// Notifier sends events to the configured endpoints. A nil notifier, or one without endpoints, does nothing.
+type Notifier struct {
+	Endpoints        []Endpoint    `json:"endpoints"`
+	Retries          int           `json:"retries,omitempty"`
+	Backoff          time.Duration `json:"backoff,omitempty"`
+	Timeout          time.Duration `json:"timeout,omitempty"`
+	ProjectLabel     string        `json:"projectlabel,omitempty"`
+	EnvironmentLabel string        `json:"environmentlabel,omitempty"`
+	Log              logr.Logger   `json:"-" yaml:"-"`
+	Client           *http.Client  `json:"-" yaml:"-"`
+	wg               sync.WaitGroup
+	pending          atomic.Int32
+}
+
+// ReadNotifier reads the notifier configuration from a file.
+func ReadNotifier(path string) (*Notifier, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	n := &Notifier{}
+	if err := yaml.NewDecoder(file).Decode(n); err != nil {
+		return nil, err
+	}
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Validate checks that the endpoints have a url and a valid template.
+func (n *Notifier) Validate() error {
+	for idx := range n.Endpoints {
+		endpoint := &n.Endpoints[idx]
+		if endpoint.URL == "" {
+			return fmt.Errorf("endpoint %s: url is required", endpoint.Name)
+		}
+		if endpoint.Template != "" {
+			tmpl, err := template.New(endpoint.Name).Parse(endpoint.Template)
+			if err != nil {
+				return fmt.Errorf("endpoint %s: %v", endpoint.Name, err)
+			}
+			endpoint.tmpl = tmpl
+		}
+	}
+	return nil
+}
+
+// Publish sends an event for the namespace to every endpoint that accepts the event type. The events are sent in the
+// background, so that a slow endpoint doesn't hold up idling.
+func (n *Notifier) Publish(ctx context.Context, eventType EventType, namespace *corev1.Namespace, message string) {
+	if n == nil || len(n.Endpoints) == 0 {
+		return
+	}
+	payload := Payload{
+		Type:      eventType,
+		Namespace: namespace.Name,
+		Message:   message,
+		Time:      time.Now().UTC(),
+	}
+	if n.ProjectLabel != "" {
+		payload.Project = namespace.Labels[n.ProjectLabel]
+	}
+	if n.EnvironmentLabel != "" {
+		payload.Environment = namespace.Labels[n.EnvironmentLabel]
+	}
+	// the events should still be sent if the context of the caller finishes first
+	ctx = context.WithoutCancel(ctx)
+	for idx := range n.Endpoints {
+		endpoint := &n.Endpoints[idx]
+		if len(endpoint.Events) > 0 && !slices.Contains(endpoint.Events, eventType) {
+			continue
+		}
+		n.wg.Add(1)
+		n.pending.Add(1)
+		go func() {
+			defer n.wg.Done()
+			defer n.pending.Add(-1)
+			if err := n.send(ctx, endpoint, payload); err != nil {
+				n.Log.Error(err, fmt.Sprintf("Unable to send %s event for %s to %s", eventType, namespace.Name, endpoint.Name))
+			}
+		}()
+	}
+}
+
+// Wait blocks until all the events that have been published are sent.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
+
+// Shutdown waits for the events that have been published to be sent, including their retries, until ctx is done. If
+// ctx is done first, it returns an error with the number of events that were still being sent.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	if n == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d events were not sent: %v", n.pending.Load(), ctx.Err())
+	}
+}
+
+// send sends the payload to the endpoint, retrying with an exponential backoff if the request fails.
+func (n *Notifier) send(ctx context.Context, endpoint *Endpoint, payload Payload) error {
+	body, err := endpoint.body(payload)
+	if err != nil {
+		return err
+	}
+	retries := n.Retries
+	if retries == 0 {
+		retries = defaultRetries
+	}
+	backoff := n.Backoff
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(ctx, endpoint, payload.Type, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff << attempt):
+		}
+	}
+}
+
+// post makes a single request to the endpoint, and returns if the request can be retried if it fails.
+func (n *Notifier) post(ctx context.Context, endpoint *Endpoint, eventType EventType, body []byte) (bool, error) {
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(eventType))
+	for key, value := range endpoint.Headers {
+		req.Header.Set(key, value)
+	}
+	if endpoint.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(body, endpoint.Secret))
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	// only retry errors that may succeed on another attempt
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+}
+
+func (e *Endpoint) body(payload Payload) ([]byte, error) {
+	if e.tmpl == nil {
+		return json.Marshal(payload)
+	}
+	var body bytes.Buffer
+	if err := e.tmpl.Execute(&body, payload); err != nil {
+		return nil, fmt.Errorf("unable to render template for endpoint %s: %v", e.Name, err)
+	}
+	return body.Bytes(), nil
+}
+
+// Sign returns the signature of the body in the format `sha256=<hex encoded hmac>`.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,193 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testNamespace = &corev1.Namespace{
+	ObjectMeta: metav1.ObjectMeta{
+		Name: "example-com-main",
+		Labels: map[string]string{
+			"lagoon.sh/project":     "example-com",
+			"lagoon.sh/environment": "main",
+		},
+	},
+}
+
+func TestReadNotifier(t *testing.T) {
+	n, err := ReadNotifier("testdata/notifier.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Retries != 2 || n.Backoff != 500*time.Millisecond {
+		t.Errorf("ReadNotifier() retries = %d backoff = %s, want 2 and 500ms", n.Retries, n.Backoff)
+	}
+	if len(n.Endpoints) != 2 || len(n.Endpoints[0].Events) != 2 || n.Endpoints[1].tmpl == nil {
+		t.Errorf("ReadNotifier() endpoints = %v, want two endpoints with events and a template", n.Endpoints)
+	}
+	if err := (&Notifier{Endpoints: []Endpoint{{Name: "test"}}}).Validate(); err == nil {
+		t.Errorf("Validate() expected an error for an endpoint without a url")
+	}
+	if err := (&Notifier{Endpoints: []Endpoint{{Name: "test", URL: "http://localhost", Template: "{{ .Type "}}}).Validate(); err == nil {
+		t.Errorf("Validate() expected an error for an invalid template")
+	}
+}
+
+func TestNotifier_Publish(t *testing.T) {
+	type request struct {
+		event     string
+		signature string
+		body      []byte
+	}
+	tests := []struct {
+		name      string
+		eventType EventType
+		endpoint  Endpoint
+		failures  int32
+		status    int
+		wantCalls int32
+		wantBody  string
+	}{
+		{
+			name:      "test1",
+			eventType: EventIdled,
+			endpoint:  Endpoint{Name: "test", Secret: "super-secret-string"},
+			status:    http.StatusOK,
+			wantCalls: 1,
+		},
+		{
+			name:      "test2",
+			eventType: EventUnidled,
+			endpoint:  Endpoint{Name: "test", Events: []EventType{EventIdled}},
+			status:    http.StatusOK,
+			wantCalls: 0,
+		},
+		{
+			name:      "test3",
+			eventType: EventForceScaled,
+			endpoint:  Endpoint{Name: "test"},
+			failures:  2,
+			status:    http.StatusOK,
+			wantCalls: 3,
+		},
+		{
+			name:      "test4",
+			eventType: EventForceIdled,
+			endpoint:  Endpoint{Name: "test"},
+			status:    http.StatusBadRequest,
+			wantCalls: 1,
+		},
+		{
+			name:      "test5",
+			eventType: EventIdled,
+			endpoint:  Endpoint{Name: "test", Template: `{"text": "{{ .Namespace }} {{ .Type }}"}`},
+			status:    http.StatusOK,
+			wantCalls: 1,
+			wantBody:  `{"text": "example-com-main idled"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			var mu sync.Mutex
+			var last request
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				last = request{event: r.Header.Get(EventHeader), signature: r.Header.Get(SignatureHeader), body: body}
+				mu.Unlock()
+				if call <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			endpoint := tt.endpoint
+			endpoint.URL = server.URL
+			n := &Notifier{
+				Endpoints:        []Endpoint{endpoint},
+				Backoff:          time.Millisecond,
+				ProjectLabel:     "lagoon.sh/project",
+				EnvironmentLabel: "lagoon.sh/environment",
+			}
+			if err := n.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			n.Publish(context.Background(), tt.eventType, testNamespace, "test message")
+			n.Wait()
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Fatalf("Publish() calls = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.wantCalls == 0 {
+				return
+			}
+			if last.event != string(tt.eventType) {
+				t.Errorf("Publish() event header = %s, want %s", last.event, tt.eventType)
+			}
+			if tt.wantBody != "" {
+				if string(last.body) != tt.wantBody {
+					t.Errorf("Publish() body = %s, want %s", last.body, tt.wantBody)
+				}
+				return
+			}
+			payload := Payload{}
+			if err := json.Unmarshal(last.body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.Type != tt.eventType || payload.Namespace != "example-com-main" ||
+				payload.Project != "example-com" || payload.Environment != "main" || payload.Message != "test message" {
+				t.Errorf("Publish() payload = %v", payload)
+			}
+			wantSignature := ""
+			if endpoint.Secret != "" {
+				wantSignature = Sign(last.body, endpoint.Secret)
+			}
+			if last.signature != wantSignature {
+				t.Errorf("Publish() signature = %s, want %s", last.signature, wantSignature)
+			}
+		})
+	}
+}
+
+func TestNotifier_PublishNil(t *testing.T) {
+	var n *Notifier
+	n.Publish(context.Background(), EventIdled, testNamespace, "test message")
+	n.Wait()
+}
+
+func TestNotifier_Shutdown(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	n := &Notifier{Endpoints: []Endpoint{{Name: "slow", URL: server.URL}}}
+	if err := n.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	n.Publish(context.Background(), EventIdled, testNamespace, "test message")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := n.Shutdown(ctx); err == nil || !strings.Contains(err.Error(), "1 events were not sent") {
+		t.Errorf("Shutdown() error = %v, want 1 event not sent", err)
+	}
+	close(release)
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,50 @@
+package recorder
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+)
+
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+
+// The reasons used for the events aergia records.
+const (
+	ReasonIdled               = "Idled"
+	ReasonUnidled             = "Unidled"
+	ReasonForceIdled          = "ForceIdled"
+	ReasonForceScaled         = "ForceScaled"
+	ReasonSkippedRunningBuild = "SkippedRunningBuild"
+	ReasonSkippedHits         = "SkippedHits"
+	ReasonSkippedCronJobs     = "SkippedCronJobs"
+	ReasonSkippedMinAwake     = "SkippedMinAwake"
+	ReasonIdlingFailed        = "IdlingFailed"
+	ReasonUnidlingFailed      = "UnidlingFailed"
+	ReasonUnidleWave          = "UnidleWave"
+)
+
+// The actions used for the events aergia records.
+const (
+	ActionIdle   = "Idle"
+	ActionUnidle = "Unidle"
+	ActionSkip   = "Skip"
+)
+
+// Namespace records an event on a namespace. The event is created in the namespace itself, so that it is shown by
+// `kubectl get events` in the namespace as well as `kubectl describe namespace`. A nil recorder does nothing.
+func Namespace(r events.EventRecorder, namespace *corev1.Namespace, eventtype, reason, action, note string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	target := namespace.DeepCopy()
+	target.Namespace = namespace.Name
+	r.Eventf(target, nil, eventtype, reason, action, note, args...)
+}
+
+// Object records an event on a namespaced object, such as a deployment or ingress. A nil recorder does nothing.
+func Object(r events.EventRecorder, obj runtime.Object, eventtype, reason, action, note string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	r.Eventf(obj, nil, eventtype, reason, action, note, args...)
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"gopkg.in/robfig/cron.v2"
+)
+
+// Job is a function that is run on a cron schedule, it is given the context of the scheduler.
+type Job struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context)
+}
+
+// Scheduler runs the idler jobs on their cron schedules. It needs leader election, so that when there are multiple
+// replicas only the leader idles environments.
+type Scheduler struct {
+	Log  logr.Logger
+	Jobs []Job
+}
+
+// NeedLeaderElection is true, only the leader should run the idlers.
+func (s *Scheduler) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs the jobs until the context is cancelled, which also cancels any jobs that are running. A job with an
+// invalid schedule is logged and not run.
+func (s *Scheduler) Start(ctx context.Context) error {
+	c := cron.New()
+	for _, job := range s.Jobs {
+		if _, err := c.AddFunc(job.Schedule, func() { job.Run(ctx) }); err != nil {
+			s.Log.Error(err, fmt.Sprintf("unable to create %s cronjob", job.Name))
+			continue
+		}
+		s.Log.Info(fmt.Sprintf("starting %s", job.Name))
+	}
+	c.Start()
+	<-ctx.Done()
+	c.Stop()
+	return nil
+}
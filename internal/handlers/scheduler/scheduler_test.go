@@ -0,0 +1,41 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func TestScheduler(t *testing.T) {
+	ran := make(chan string, 10)
+	s := &Scheduler{
+		Log: logr.Discard(),
+		Jobs: []Job{
+			{Name: "invalid", Schedule: "not a schedule", Run: func(context.Context) { ran <- "invalid" }},
+			{Name: "every second", Schedule: "* * * * * *", Run: func(context.Context) { ran <- "every second" }},
+		},
+	}
+	if !s.NeedLeaderElection() {
+		t.Fatalf("NeedLeaderElection() = false, want true")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- s.Start(ctx)
+	}()
+	select {
+	case got := <-ran:
+		if got != "every second" {
+			t.Errorf("ran %s, want every second", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job was not run")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The attributes aergia adds to its spans.
+const (
+	AttributeRequestID   = attribute.Key("aergia.request_id")
+	AttributeIngress     = attribute.Key("aergia.ingress")
+	AttributeIdler       = attribute.Key("aergia.idler")
+	AttributeDecision    = attribute.Key("aergia.decision")
+	AttributeReason      = attribute.Key("aergia.reason")
+	AttributeOutcome     = attribute.Key("aergia.outcome")
+	AttributeWave        = attribute.Key("aergia.wave")
+	AttributeNamespace   = attribute.Key("k8s.namespace.name")
+	AttributeDeployment  = attribute.Key("k8s.deployment.name")
+	AttributeStatefulSet = attribute.Key("k8s.statefulset.name")
+)
+
+const instrumentationName = "github.com/uselagoon/aergia-controller"
+
+// Config is how spans are exported, no spans are exported if the endpoint is empty.
+type Config struct {
+	// Endpoint is the host:port of an OTLP gRPC collector, like localhost:4317.
+	Endpoint string
+	// Insecure disables TLS to the collector, for a collector running alongside aergia.
+	Insecure bool
+	// SampleRatio is the ratio of new traces that are sampled, traces started by a sampled parent are always sampled.
+	SampleRatio float64
+}
+
+/*
+Setup sets the global tracer provider to export spans to the collector in the config, and returns a function that
+flushes and stops the exporter. If there is no endpoint, the global tracer provider is left as the default that
+doesn't record anything.
+*/
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if config.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the otlp exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", "aergia")))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the tracing resource: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+type linkKey struct{}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context that adds the request id as an attribute to every span started from it.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	if requestID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request id from the context, if there is one.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+/*
+Detach returns a context for work that carries on after the span in ctx has ended, like the unidling started by a
+request. It isn't cancelled with ctx, and the first span started from it is the root of a new trace that is linked to
+the span in ctx, rather than a child of it. The request id is kept, so both traces can be found by it.
+*/
+func Detach(ctx context.Context) context.Context {
+	detached := WithRequestID(context.Background(), RequestID(ctx))
+	link := trace.LinkFromContext(ctx)
+	if !link.SpanContext.IsValid() {
+		return detached
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		link.Attributes = append(link.Attributes, AttributeRequestID.String(requestID))
+	}
+	return context.WithValue(detached, linkKey{}, link)
+}
+
+// Start starts a span from the global tracer provider.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{}
+	if link, ok := ctx.Value(linkKey{}).(trace.Link); ok {
+		// only the first span of a detached context starts a new trace, its children are part of that trace
+		ctx = context.WithValue(ctx, linkKey{}, nil)
+		opts = append(opts, trace.WithNewRoot(), trace.WithLinks(link))
+	}
+	if requestID := RequestID(ctx); requestID != "" {
+		attrs = append(attrs, AttributeRequestID.String(requestID))
+	}
+	opts = append(opts, trace.WithAttributes(attrs...))
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// StartRequest starts the span for an http request, continuing the trace from the request headers if there is one.
+func StartRequest(r *http.Request, name, requestID string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx = WithRequestID(ctx, requestID)
+	attrs = append(attrs, attribute.String("http.request.method", r.Method), attribute.String("url.path", r.URL.Path))
+	return Start(ctx, name, attrs...)
+}
+
+// End records the error, if there is one, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStartRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		requestID   string
+		detach      bool
+		wantTraceID string
+	}{
+		{
+			name:      "test1",
+			requestID: "4c9f6a4bd9c5a2e6f1b3d7e8a0c2b4d6",
+		},
+		{
+			name:        "test2",
+			traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+			requestID:   "4c9f6a4bd9c5a2e6f1b3d7e8a0c2b4d6",
+			wantTraceID: "0af7651916cd43dd8448eb211c80319c",
+		},
+		{
+			name:      "test3",
+			requestID: "4c9f6a4bd9c5a2e6f1b3d7e8a0c2b4d6",
+			detach:    true,
+		},
+		{
+			name:   "test4",
+			detach: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans := tracetest.NewSpanRecorder()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+			if _, err := Setup(context.Background(), Config{}); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+			ctx, span := StartRequest(r, "UnidleRequest", tt.requestID)
+			if tt.detach {
+				ctx = Detach(ctx)
+			}
+			unidleCtx, unidleSpan := Start(ctx, "Unidle")
+			_, waitSpan := Start(unidleCtx, "WaitForDeployment")
+			waitSpan.End()
+			unidleSpan.End()
+			span.End()
+
+			ended := spans.Ended()
+			if len(ended) != 3 {
+				t.Fatalf("ended spans = %d, want 3", len(ended))
+			}
+			wait, unidle, request := ended[0], ended[1], ended[2]
+			if tt.wantTraceID != "" && request.SpanContext().TraceID().String() != tt.wantTraceID {
+				t.Errorf("request trace id = %s, want %s", request.SpanContext().TraceID(), tt.wantTraceID)
+			}
+			// the children of the unidle span are always in the same trace as it
+			if wait.Parent().SpanID() != unidle.SpanContext().SpanID() {
+				t.Errorf("wait span parent = %s, want %s", wait.Parent().SpanID(), unidle.SpanContext().SpanID())
+			}
+			if tt.detach {
+				// a detached unidle is a new trace, linked to the request
+				if unidle.SpanContext().TraceID() == request.SpanContext().TraceID() {
+					t.Errorf("detached unidle span is in the request trace %s", request.SpanContext().TraceID())
+				}
+				if len(unidle.Links()) != 1 || unidle.Links()[0].SpanContext.SpanID() != request.SpanContext().SpanID() {
+					t.Errorf("detached unidle span links = %+v, want a link to the request span", unidle.Links())
+				}
+			} else if unidle.Parent().SpanID() != request.SpanContext().SpanID() {
+				t.Errorf("unidle span parent = %s, want %s", unidle.Parent().SpanID(), request.SpanContext().SpanID())
+			}
+			for _, s := range ended {
+				got := ""
+				for _, attr := range s.Attributes() {
+					if attr.Key == AttributeRequestID {
+						got = attr.Value.AsString()
+					}
+				}
+				if got != tt.requestID {
+					t.Errorf("%s span request id = %q, want %q", s.Name(), got, tt.requestID)
+				}
+			}
+		})
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	networkv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
@@ -17,7 +22,11 @@ import (
 	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (h *Unidler) checkForceScaled(ctx context.Context, ns string, opLog logr.Logger) bool {
+// CheckForceScaled returns true if any of the deployments in the namespace have been force scaled, force scaled
+// environments are only unidled by a deployment.
+func (h *Unidler) CheckForceScaled(ctx context.Context, ns string, opLog logr.Logger) bool {
+	ctx, span := tracing.Start(ctx, "CheckForceScaled")
+	defer span.End()
 	// get the deployments in the namespace if they have the `watch=true` label
 	labelRequirements1, _ := labels.NewRequirement("idling.amazee.io/force-scaled", selection.Equals, []string{"true"})
 	listOption := (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
@@ -37,29 +46,156 @@ func (h *Unidler) checkForceScaled(ctx context.Context, ns string, opLog logr.Lo
 	return false
 }
 
-func (h *Unidler) hasRunningPod(ctx context.Context, namespace, deployment string) wait.ConditionWithContextFunc {
+// +kubebuilder:rbac:groups="",resources=services,verbs=list;get;watch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=list;get;watch
+
+// UnidleTimeoutAnnotation sets how long to wait for the workloads of a namespace to be ready when it is unidled.
+const UnidleTimeoutAnnotation = "idling.amazee.io/unidle-timeout"
+
+// pollTimeout returns how long to wait for the workloads of the namespace to be ready.
+func pollTimeout(namespace *corev1.Namespace, opLog logr.Logger) time.Duration {
+	value, ok := namespace.Annotations[UnidleTimeoutAnnotation]
+	if !ok {
+		return defaultPollTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		opLog.Info(fmt.Sprintf("Invalid %s annotation %s, using the default of %s", UnidleTimeoutAnnotation, value, defaultPollTimeout))
+		return defaultPollTimeout
+	}
+	return timeout
+}
+
+/*
+hasReadyDeployment checks that all of the replicas of the deployment are ready, and that every service selecting its
+pods has a ready endpoint, so that the ingress isn't restored until the environment can serve requests.
+*/
+func (h *Unidler) hasReadyDeployment(ctx context.Context, namespace, deployment string) wait.ConditionWithContextFunc {
 	return func(context.Context) (bool, error) {
 		var d appsv1.Deployment
 		if err := h.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: deployment}, &d); err != nil {
 			return false, err
 		}
-		var pods corev1.PodList
-		listOption := (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
-			ctrlClient.MatchingLabelsSelector{
-				Selector: labels.SelectorFromSet(d.Spec.Selector.MatchLabels),
-			},
-		})
-		if err := h.Client.List(ctx, &pods, listOption); err != nil {
+		if d.Spec.Replicas == nil || *d.Spec.Replicas == 0 {
+			// nothing to wait for
+			return true, nil
+		}
+		return h.deploymentReady(ctx, d)
+	}
+}
+
+// deploymentReady checks that a scaled up deployment has all of its replicas ready, and its services have ready endpoints.
+func (h *Unidler) deploymentReady(ctx context.Context, d appsv1.Deployment) (bool, error) {
+	// the status must be for the scaled up deployment
+	if d.Spec.Replicas == nil || d.Status.ObservedGeneration < d.Generation || d.Status.ReadyReplicas < *d.Spec.Replicas {
+		return false, nil
+	}
+	return h.hasReadyEndpoints(ctx, d.Namespace, d.Spec.Template.Labels)
+}
+
+// hasReadyEndpoints checks that every service in the namespace that selects pods with the labels has a ready endpoint.
+func (h *Unidler) hasReadyEndpoints(ctx context.Context, namespace string, podLabels map[string]string) (bool, error) {
+	services := &corev1.ServiceList{}
+	if err := h.Client.List(ctx, services, ctrlClient.InNamespace(namespace)); err != nil {
+		return false, err
+	}
+	for _, service := range services.Items {
+		if len(service.Spec.Selector) == 0 || !labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(podLabels)) {
+			continue
+		}
+		endpointSlices := &discoveryv1.EndpointSliceList{}
+		if err := h.Client.List(ctx, endpointSlices, ctrlClient.InNamespace(namespace), ctrlClient.MatchingLabels{
+			discoveryv1.LabelServiceName: service.Name,
+		}); err != nil {
 			return false, err
 		}
-		if len(pods.Items) == 0 {
+		if !hasReadyEndpoint(endpointSlices.Items) {
 			return false, nil
 		}
-		return pods.Items[0].Status.Phase == "Running", nil
+	}
+	return true, nil
+}
+
+func hasReadyEndpoint(endpointSlices []discoveryv1.EndpointSlice) bool {
+	for _, endpointSlice := range endpointSlices {
+		for _, endpoint := range endpointSlice.Endpoints {
+			// an unknown ready condition is treated as ready
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *Unidler) hasReadyStatefulSet(ctx context.Context, namespace, statefulSet string) wait.ConditionWithContextFunc {
+	return func(context.Context) (bool, error) {
+		var sts appsv1.StatefulSet
+		if err := h.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: statefulSet}, &sts); err != nil {
+			return false, err
+		}
+		if sts.Spec.Replicas == nil || *sts.Spec.Replicas == 0 {
+			// nothing to wait for
+			return true, nil
+		}
+		return sts.Status.ReadyReplicas >= *sts.Spec.Replicas, nil
+	}
+}
+
+/*
+unsuspendCronJobs restores the suspend state of any cronjobs that were suspended by the idler.
+cronjobs that were suspended by a force scale are left suspended, the next deployment will restore them.
+*/
+func (h *Unidler) unsuspendCronJobs(ctx context.Context, ns string, listOption *ctrlClient.ListOptions, opLog logr.Logger) {
+	ctx, span := tracing.Start(ctx, "UnsuspendCronJobs")
+	defer span.End()
+	cronJobs := &batchv1.CronJobList{}
+	if err := h.Client.List(ctx, cronJobs, listOption); err != nil {
+		opLog.Info(fmt.Sprintf("Unable to get any cronjobs - %s", ns))
+		return
+	}
+	for _, cronJob := range cronJobs.Items {
+		if cronJob.Labels["idling.amazee.io/idled"] != "true" {
+			continue
+		}
+		if cronJob.Labels["idling.amazee.io/force-scaled"] == "true" {
+			opLog.Info(fmt.Sprintf("CronJob %s was force scaled, leaving suspended - %s", cronJob.Name, ns))
+			continue
+		}
+		// default to not suspended, unless the cronjob was suspended before it was idled
+		suspend := false
+		if value, ok := cronJob.Annotations["idling.amazee.io/unidle-suspend"]; ok {
+			if wasSuspended, err := strconv.ParseBool(value); err == nil {
+				suspend = wasSuspended
+			}
+		}
+		mergePatch, _ := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{
+				"suspend": suspend,
+			},
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{
+					"idling.amazee.io/idled":       "false",
+					"idling.amazee.io/force-idled": nil,
+				},
+				"annotations": map[string]interface{}{
+					"idling.amazee.io/idled-at":       nil,
+					"idling.amazee.io/unidle-suspend": nil,
+				},
+			},
+		})
+		patchCronJob := cronJob.DeepCopy()
+		if err := h.Client.Patch(ctx, patchCronJob, ctrlClient.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+			opLog.Info(fmt.Sprintf("Error restoring cronjob %s - %s", cronJob.Name, ns))
+		} else {
+			opLog.Info(fmt.Sprintf("CronJob %s suspend restored to %t - %s", cronJob.Name, suspend, ns))
+		}
 	}
 }
 
 func (h *Unidler) removeCodeFromIngress(ctx context.Context, ns string, opLog logr.Logger) {
+	ctx, span := tracing.Start(ctx, "RemoveCodeFromIngress")
+	defer span.End()
 	// get the ingresses in the namespace
 	listOption := (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
 		ctrlClient.InNamespace(ns),
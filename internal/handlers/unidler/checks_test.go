@@ -0,0 +1,155 @@
+package unidler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPollTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        time.Duration
+	}{
+		{
+			name: "test1",
+			want: defaultPollTimeout,
+		},
+		{
+			name:        "test2",
+			annotations: map[string]string{UnidleTimeoutAnnotation: "5m"},
+			want:        5 * time.Minute,
+		},
+		{
+			name:        "test3",
+			annotations: map[string]string{UnidleTimeoutAnnotation: "5"},
+			want:        defaultPollTimeout,
+		},
+		{
+			name:        "test4",
+			annotations: map[string]string{UnidleTimeoutAnnotation: "-1m"},
+			want:        defaultPollTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main", Annotations: tt.annotations}}
+			if got := pollTimeout(namespace, logr.Discard()); got != tt.want {
+				t.Errorf("pollTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnidler_hasReadyDeployment(t *testing.T) {
+	ready := true
+	notReady := false
+	tests := []struct {
+		name               string
+		generation         int64
+		observedGeneration int64
+		readyReplicas      int32
+		endpoints          []discoveryv1.Endpoint
+		want               bool
+	}{
+		{
+			name:               "test1",
+			generation:         2,
+			observedGeneration: 2,
+			readyReplicas:      2,
+			endpoints:          []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}}},
+			want:               true,
+		},
+		{
+			name:               "test2",
+			generation:         2,
+			observedGeneration: 2,
+			readyReplicas:      1,
+			endpoints:          []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}}},
+			want:               false,
+		},
+		{
+			name:               "test3",
+			generation:         3,
+			observedGeneration: 2,
+			readyReplicas:      2,
+			endpoints:          []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}}},
+			want:               false,
+		},
+		{
+			name:               "test4",
+			generation:         2,
+			observedGeneration: 2,
+			readyReplicas:      2,
+			endpoints:          []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}}},
+			want:               false,
+		},
+		{
+			name:               "test5",
+			generation:         2,
+			observedGeneration: 2,
+			readyReplicas:      2,
+			want:               false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			replicas := int32(2)
+			objects := []ctrlClient.Object{
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "example-com-main", Generation: tt.generation},
+					Spec: appsv1.DeploymentSpec{
+						Replicas: &replicas,
+						Template: corev1.PodTemplateSpec{
+							ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "nginx", "version": "1"}},
+						},
+					},
+					Status: appsv1.DeploymentStatus{ObservedGeneration: tt.observedGeneration, ReadyReplicas: tt.readyReplicas},
+				},
+				&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "example-com-main"},
+					Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "nginx"}},
+				},
+				// a service for other pods doesn't need to be ready
+				&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Name: "php", Namespace: "example-com-main"},
+					Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "php"}},
+				},
+			}
+			if tt.endpoints != nil {
+				objects = append(objects, &discoveryv1.EndpointSlice{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "nginx-abcde",
+						Namespace: "example-com-main",
+						Labels:    map[string]string{discoveryv1.LabelServiceName: "nginx"},
+					},
+					AddressType: discoveryv1.AddressTypeIPv4,
+					Endpoints:   tt.endpoints,
+				})
+			}
+			h := &Unidler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()}
+			got, err := h.hasReadyDeployment(context.Background(), "example-com-main", "nginx")(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("hasReadyDeployment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
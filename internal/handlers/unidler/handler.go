@@ -1,18 +1,20 @@
 package unidler
 
 import (
-	"context"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -21,7 +23,8 @@ import (
 
 func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.Background()
+		ctx, span := tracing.StartRequest(r, "UnidleRequest", r.Header.Get(RequestID))
+		defer span.End()
 		opLog := h.Log.WithValues("custom-default-backend", "request")
 		start := time.Now()
 		// if debug is enabled, then set the headers in the response too
@@ -36,26 +39,11 @@ func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Re
 			w.Header().Set(ServicePort, r.Header.Get(ServicePort))
 			w.Header().Set(RequestID, r.Header.Get(RequestID))
 		}
-		format := r.Header.Get(FormatHeader)
-		if format == "" {
-			format = "text/html"
-		}
-
-		// Browser may send a list of accepted formats, but we only want the first reported content type for the response
-		if strings.Contains(format, ",") {
-			format = strings.Split(format, ",")[0]
-		}
-
-		w.Header().Set(ContentType, format)
+		format := negotiateFormat(r)
 		w.Header().Set(AergiaHeader, "true")
 		w.Header().Set(CacheControl, "private,no-store")
 
-		errCode := r.Header.Get(CodeHeader)
-		code, err := strconv.Atoi(errCode)
-		if err != nil {
-			code = h.DefaultHTTPResponseCode
-		}
-		w.WriteHeader(code)
+		code := h.statusCode(r)
 		ns := r.Header.Get(Namespace)
 		ingressName := r.Header.Get(IngressName)
 		hostname := ""
@@ -79,21 +67,29 @@ func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Re
 		}
 		// check if the namespace exists so we know this is somewhat legitimate request
 		if ns != "" {
+			span.SetAttributes(tracing.AttributeNamespace.String(ns))
 			namespace := &corev1.Namespace{}
-			if err := h.Client.Get(ctx, types.NamespacedName{
+			lookupCtx, lookupSpan := tracing.Start(ctx, "GetNamespace")
+			err := h.Client.Get(lookupCtx, types.NamespacedName{
 				Name: ns,
-			}, namespace); err != nil {
+			}, namespace)
+			tracing.End(lookupSpan, err)
+			if err != nil {
 				opLog.Info(fmt.Sprintf("unable to get any namespaces: %v", err))
+				w.WriteHeader(code)
 				return
 			}
 			ingress := &networkv1.Ingress{}
 			if ingressName != "" {
-				if err := h.Client.Get(ctx, types.NamespacedName{
+				lookupCtx, lookupSpan := tracing.Start(ctx, "GetIngress", tracing.AttributeIngress.String(ingressName))
+				err := h.Client.Get(lookupCtx, types.NamespacedName{
 					Namespace: ns,
 					Name:      ingressName,
-				}, ingress); err != nil {
+				}, ingress)
+				tracing.End(lookupSpan, err)
+				if err != nil {
 					opLog.Info(fmt.Sprintf("Unable to get the ingress %s in %s", ingressName, ns))
-					h.genericError(w, r, opLog, format, path, 400)
+					h.genericError(w, r, opLog, format, path, StateError, 400)
 					h.setMetrics(r, start)
 					return
 				}
@@ -102,8 +98,12 @@ func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Re
 					ctrlClient.InNamespace(ns),
 				})
 				ingresses := &networkv1.IngressList{}
-				if err := h.Client.List(ctx, ingresses, listOption); err != nil {
+				lookupCtx, lookupSpan := tracing.Start(ctx, "ListIngresses")
+				err := h.Client.List(lookupCtx, ingresses, listOption)
+				tracing.End(lookupSpan, err)
+				if err != nil {
 					opLog.Info(fmt.Sprintf("Unable to get any ingress - %s", ns))
+					w.WriteHeader(code)
 					return
 				}
 				for _, ingressss := range ingresses.Items {
@@ -116,14 +116,58 @@ func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Re
 					}
 				}
 			}
+			// requests from an idled httproute include the name of the route, otherwise look for a route by hostname
+			// if there is no matching ingress
+			ingressName = ingress.Name
+			annotations := ingress.Annotations
+			routeName := r.Header.Get(HTTPRouteName)
+			if h.HTTPRoutes && (routeName != "" || ingress.Name == "") {
+				routeHostname := hostname
+				if routeHostname == "" {
+					routeHostname = r.Host
+					if host, _, err := net.SplitHostPort(r.Host); err == nil {
+						routeHostname = host
+					}
+				}
+				route, err := h.findHTTPRoute(ctx, ns, routeName, routeHostname)
+				if err != nil {
+					opLog.Info(fmt.Sprintf("Unable to get the httproute %s in %s", routeName, ns))
+					h.genericError(w, r, opLog, format, path, StateError, 400)
+					h.setMetrics(r, start)
+					return
+				}
+				if route.Name != "" {
+					ingressName = route.Name
+					annotations = route.Annotations
+				}
+			}
+			span.SetAttributes(tracing.AttributeIngress.String(ingressName))
 			// if hmac verification is enabled, perform the verification of the request
-			signedNamespace, verfied := h.verifyRequest(r, namespace, ingress)
+			_, verifySpan := tracing.Start(ctx, "VerifyRequest")
+			signedNamespace, verfied := h.verifyRequest(r, namespace, annotations)
+			verifySpan.SetAttributes(attribute.Bool("aergia.verified", verfied))
+			verifySpan.End()
 
 			xForwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
 			trueClientIP := r.Header.Get("True-Client-IP")
 			requestUserAgent := r.Header.Get("User-Agent")
 
-			allowUnidle := h.checkAccess(namespace.Annotations, ingress.Annotations, requestUserAgent, trueClientIP, xForwardedFor)
+			_, accessSpan := tracing.Start(ctx, "CheckAccess")
+			allowUnidle := h.checkAccess(namespace.Annotations, annotations, requestUserAgent, trueClientIP, xForwardedFor)
+			accessSpan.SetAttributes(attribute.Bool("aergia.allowed", allowUnidle))
+			accessSpan.End()
+			// the unidle page requests the progress of unidling, it is verified in the same way as unidling the environment
+			if allowUnidle && isProgressRequest(r) {
+				if !verfied {
+					metrics.VerificationRequired.Inc()
+					w.Header().Set("X-Aergia-Verification-Required", "true")
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				} else {
+					h.progressHandler(w, r, opLog, namespace)
+				}
+				h.setMetrics(r, start)
+				return
+			}
 			// then run checks to start to unidle the environment
 			if allowUnidle {
 				// if a namespace exists, it means that the custom-http-errors code is defined in the ingress object
@@ -132,11 +176,11 @@ func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Re
 					opLog.Info(fmt.Sprintf("Request for %s verfied: %t from xff:%s; tcip:%s; ua: %s, ", ns, verfied, xForwardedFor, trueClientIP, requestUserAgent))
 				}
 
-				file := fmt.Sprintf("%v/unidle.html", path)
-				forceScaled := h.checkForceScaled(ctx, ns, opLog)
+				state := StateUnidling
+				forceScaled := h.CheckForceScaled(ctx, ns, opLog)
 				if forceScaled {
 					// if this has been force scaled, return the force scaled landing page
-					file = fmt.Sprintf("%v/forced.html", path)
+					state = StateForced
 				} else {
 					// only unidle environments that aren't force scaled
 					// actually do the unidling here, lock to prevent multiple unidle operations from running
@@ -148,59 +192,63 @@ func (h *Unidler) ingressHandler(path string) func(http.ResponseWriter, *http.Re
 						w.Header().Set("X-Aergia-Allowed", "true")
 						_, ok := h.Locks.Load(ns)
 						if !ok {
+							// only requests that would start unidling are throttled, the rest are shown the progress
+							client := clientIP(xForwardedFor, r.RemoteAddr)
+							if reason, retryAfter := h.throttle(ctx, namespace, client, opLog); reason != "" {
+								opLog.Info(fmt.Sprintf("Unidle request for %s from %s throttled by the %s limit", ns, client, reason))
+								metrics.ThrottledRequests.WithLabelValues(reason).Inc()
+								w.Header().Set("X-Aergia-Throttled", reason)
+								h.throttled(w, r, opLog, format, path, ns, reason, retryAfter)
+								h.setMetrics(r, start)
+								return
+							}
 							_, _ = h.Locks.LoadOrStore(ns, ns)
 							if h.Debug {
 								opLog.Info(fmt.Sprintf("Unidle request for %s verfied", ns))
 							}
-							go h.Unidle(ctx, namespace, opLog)
+							// the unidle carries on after the response, so it is traced separately and linked to this request
+							go h.Unidle(tracing.Detach(ctx), namespace, opLog)
 						}
 					} else {
 						metrics.VerificationRequired.Inc()
 						w.Header().Set("X-Aergia-Verification-Required", "true")
 					}
 				}
-				if h.Debug {
-					opLog.Info(fmt.Sprintf("Serving custom error response for code %v and format %v from file %v", code, format, file))
-				}
-				// then return the unidle template to the user
-				tmpl := template.Must(template.ParseFiles(file))
-				_ = tmpl.ExecuteTemplate(w, "base", pageData{
+				// then return the unidle response to the user
+				h.render(w, r, opLog, format, path, pageData{
 					ErrorCode:       strconv.Itoa(code),
 					FormatHeader:    r.Header.Get(FormatHeader),
 					CodeHeader:      r.Header.Get(CodeHeader),
 					ContentType:     r.Header.Get(ContentType),
 					OriginalURI:     r.Header.Get(OriginalURI),
 					Namespace:       ns,
-					IngressName:     ingress.Name,
+					IngressName:     ingressName,
 					ServiceName:     r.Header.Get(ServiceName),
 					ServicePort:     r.Header.Get(ServicePort),
 					RequestID:       r.Header.Get(RequestID),
 					RefreshInterval: h.RefreshInterval,
 					Verifier:        signedNamespace,
+					State:           state,
+					RetryAfter:      h.RefreshInterval,
 				})
 			} else {
 				// respond with forbidden
 				w.Header().Set("X-Aergia-Denied", "true")
 				metrics.BlockedRequests.Inc()
-				h.genericError(w, r, opLog, format, path, 403)
+				h.genericError(w, r, opLog, format, path, StateBlocked, 403)
 			}
 		} else {
 			w.Header().Set("X-Aergia-Denied", "true")
 			w.Header().Set("X-Aergia-No-Namespace", "true")
 			metrics.NoNamespaceRequests.Inc()
-			h.genericError(w, r, opLog, format, path, code)
+			h.genericError(w, r, opLog, format, path, StateError, code)
 		}
 		h.setMetrics(r, start)
 	}
 }
 
-func (h *Unidler) genericError(w http.ResponseWriter, r *http.Request, opLog logr.Logger, format, path string, code int) {
-	file := fmt.Sprintf("%v/error.html", path)
-	if h.Debug {
-		opLog.Info(fmt.Sprintf("Serving custom error response for code %v and format %v from file %v", code, format, file))
-	}
-	tmpl := template.Must(template.ParseFiles(file))
-	_ = tmpl.ExecuteTemplate(w, "base", pageData{
+func (h *Unidler) genericError(w http.ResponseWriter, r *http.Request, opLog logr.Logger, format responseFormat, path, state string, code int) {
+	h.render(w, r, opLog, format, path, pageData{
 		ErrorCode:       strconv.Itoa(code),
 		ErrorMessage:    http.StatusText(code),
 		FormatHeader:    r.Header.Get(FormatHeader),
@@ -213,13 +261,43 @@ func (h *Unidler) genericError(w http.ResponseWriter, r *http.Request, opLog log
 		ServicePort:     r.Header.Get(ServicePort),
 		RequestID:       r.Header.Get(RequestID),
 		RefreshInterval: h.RefreshInterval,
+		State:           state,
+	})
+}
+
+/*
+throttled responds to an unidle request that was throttled. Requests over the rate limits get a 429, and requests
+during the cooldown get a 503, both with how long until they can be retried.
+*/
+func (h *Unidler) throttled(w http.ResponseWriter, r *http.Request, opLog logr.Logger, format responseFormat, path, ns, reason string, retryAfter time.Duration) {
+	code := http.StatusTooManyRequests
+	message := "Too many requests to unidle the environment, try again later"
+	if reason == ThrottledCooldown {
+		code = http.StatusServiceUnavailable
+		message = "The environment was idled recently, try again later"
+	}
+	h.render(w, r, opLog, format, path, pageData{
+		ErrorCode:       strconv.Itoa(code),
+		ErrorMessage:    message,
+		FormatHeader:    r.Header.Get(FormatHeader),
+		CodeHeader:      r.Header.Get(CodeHeader),
+		ContentType:     r.Header.Get(ContentType),
+		OriginalURI:     r.Header.Get(OriginalURI),
+		Namespace:       ns,
+		IngressName:     r.Header.Get(IngressName),
+		ServiceName:     r.Header.Get(ServiceName),
+		ServicePort:     r.Header.Get(ServicePort),
+		RequestID:       r.Header.Get(RequestID),
+		RefreshInterval: h.RefreshInterval,
+		State:           StateThrottled,
+		RetryAfter:      int(math.Ceil(retryAfter.Seconds())),
 	})
 }
 
 // handle verifying the namespace name is signed by our secret
-func (h *Unidler) verifyRequest(r *http.Request, ns *corev1.Namespace, ingress *networkv1.Ingress) (string, bool) {
+func (h *Unidler) verifyRequest(r *http.Request, ns *corev1.Namespace, annotations map[string]string) (string, bool) {
 	if h.VerifiedUnidling {
-		if val, ok := ingress.Annotations["idling.amazee.io/disable-request-verification"]; ok {
+		if val, ok := annotations["idling.amazee.io/disable-request-verification"]; ok {
 			t, _ := strconv.ParseBool(val)
 			if t {
 				return "", true
@@ -235,15 +313,33 @@ func (h *Unidler) verifyRequest(r *http.Request, ns *corev1.Namespace, ingress *
 		}
 		// if hmac verification is enabled, perform the verification of the request
 		signedNamespace := hmacSigner(ns.Name, []byte(h.VerifiedSecret))
-		verifier := r.Header.Get(AergiaVerifier)
+		verifier := requestVerifier(r)
 		metrics.VerificationRequests.Inc()
 		return signedNamespace, hmacVerifier(ns.Name, verifier, []byte(h.VerifiedSecret))
 	}
 	return "", true
 }
 
+/*
+requestVerifier returns the verifier of the request from the header. The unidle page can't set headers when it streams
+the progress, so progress requests can also use the verifier query parameter of the original request. It is never read
+for other requests, so a url with the verifier in it can't unidle an environment.
+*/
+func requestVerifier(r *http.Request) string {
+	if verifier := r.Header.Get(AergiaVerifier); verifier != "" || !isProgressRequest(r) {
+		return verifier
+	}
+	if uri := r.Header.Get(OriginalURI); uri != "" {
+		if u, err := url.Parse(uri); err == nil {
+			return u.Query().Get("verifier")
+		}
+	}
+	return r.URL.Query().Get("verifier")
+}
+
 func (h *Unidler) setMetrics(r *http.Request, start time.Time) {
-	duration := time.Since(start).Seconds()
+	// the histogram is in milliseconds
+	duration := float64(time.Since(start)) / float64(time.Millisecond)
 
 	proto := strconv.Itoa(r.ProtoMajor)
 	proto = fmt.Sprintf("%s.%s", proto, strconv.Itoa(r.ProtoMinor))
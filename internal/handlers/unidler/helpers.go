@@ -23,6 +23,7 @@ func removeStatusCode(codes string, code string) *string {
 	return &returnCodes
 }
 
+// ReadSliceFromFile reads a list file with one entry per line, empty lines and lines starting with # are ignored.
 func ReadSliceFromFile(path string) ([]string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -33,7 +34,12 @@ func ReadSliceFromFile(path string) ([]string, error) {
 	var lines []string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+		// skip empty lines and comments
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
 	}
 	return lines, scanner.Err()
 }
@@ -0,0 +1,102 @@
+package unidler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;update;patch
+
+// restoreHTTPRoutes restores the original rules of any HTTPRoute that was redirected to the backend by the idler.
+func (h *Unidler) restoreHTTPRoutes(ctx context.Context, ns string, opLog logr.Logger) {
+	ctx, span := tracing.Start(ctx, "RestoreHTTPRoutes")
+	defer span.End()
+	listOption := (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
+		ctrlClient.InNamespace(ns),
+	})
+	routes := &gatewayv1.HTTPRouteList{}
+	if err := h.Client.List(ctx, routes, listOption); err != nil {
+		opLog.Info(fmt.Sprintf("Unable to get any httproutes - %s", ns))
+		return
+	}
+	for _, route := range routes.Items {
+		value, ok := route.Annotations["idling.amazee.io/unidle-rules"]
+		if !ok {
+			continue
+		}
+		rules := []gatewayv1.HTTPRouteRule{}
+		if err := json.Unmarshal([]byte(value), &rules); err != nil {
+			// leave the route idled, replacing the rules with something invalid would break it completely
+			opLog.Info(fmt.Sprintf("Error decoding the original rules of httproute %s - %s", route.Name, ns))
+			continue
+		}
+		mergePatch, _ := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{
+				"rules": rules,
+			},
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{
+					"idling.amazee.io/idled": "false",
+				},
+				"annotations": map[string]interface{}{
+					"idling.amazee.io/unidle-rules": nil,
+				},
+			},
+		})
+		patchRoute := route.DeepCopy()
+		if err := h.Client.Patch(ctx, patchRoute, ctrlClient.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+			// log it but try and patch the rest of the httproutes anyway
+			opLog.Info(fmt.Sprintf("Error restoring rules on httproute %s - %s", route.Name, ns))
+		} else {
+			opLog.Info(fmt.Sprintf("HTTPRoute %s rules restored - %s", route.Name, ns))
+		}
+	}
+}
+
+// findHTTPRoute gets the HTTPRoute by name, or if no name is provided, the first HTTPRoute in the namespace with a
+// hostname that matches the requested hostname.
+func (h *Unidler) findHTTPRoute(ctx context.Context, ns, name, hostname string) (*gatewayv1.HTTPRoute, error) {
+	route := &gatewayv1.HTTPRoute{}
+	if name != "" {
+		if err := h.Client.Get(ctx, types.NamespacedName{
+			Namespace: ns,
+			Name:      name,
+		}, route); err != nil {
+			return nil, err
+		}
+		return route, nil
+	}
+	listOption := (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
+		ctrlClient.InNamespace(ns),
+	})
+	routes := &gatewayv1.HTTPRouteList{}
+	if err := h.Client.List(ctx, routes, listOption); err != nil {
+		return nil, err
+	}
+	for _, r := range routes.Items {
+		for _, routeHostname := range r.Spec.Hostnames {
+			if matchHostname(string(routeHostname), hostname) {
+				return r.DeepCopy(), nil
+			}
+		}
+	}
+	return route, nil
+}
+
+// matchHostname checks if a hostname matches an HTTPRoute hostname, which can have a wildcard as the first label.
+func matchHostname(routeHostname, hostname string) bool {
+	routeHostname = strings.ToLower(routeHostname)
+	hostname = strings.ToLower(hostname)
+	if suffix, ok := strings.CutPrefix(routeHostname, "*"); ok {
+		return strings.HasSuffix(hostname, suffix) && len(hostname) > len(suffix)
+	}
+	return routeHostname == hostname
+}
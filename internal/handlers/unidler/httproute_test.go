@@ -0,0 +1,111 @@
+package unidler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+)
+
+func TestMatchHostname(t *testing.T) {
+	tests := []struct {
+		name          string
+		routeHostname string
+		hostname      string
+		want          bool
+	}{
+		{
+			name:          "test1",
+			routeHostname: "example.com",
+			hostname:      "Example.com",
+			want:          true,
+		},
+		{
+			name:          "test2",
+			routeHostname: "*.example.com",
+			hostname:      "www.example.com",
+			want:          true,
+		},
+		{
+			name:          "test3",
+			routeHostname: "*.example.com",
+			hostname:      "example.com",
+			want:          false,
+		},
+		{
+			name:          "test4",
+			routeHostname: "example.com",
+			hostname:      "www.example.com",
+			want:          false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchHostname(tt.routeHostname, tt.hostname); got != tt.want {
+				t.Errorf("matchHostname() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreHTTPRoutes(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatewayv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	route := &gatewayv1.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "example-com-main",
+			Labels: map[string]string{
+				"idling.amazee.io/idled": "true",
+			},
+			Annotations: map[string]string{
+				"idling.amazee.io/unidle-rules": `[{"backendRefs":[{"name":"nginx","port":8080}]}]`,
+			},
+		},
+		Spec: gatewayv1.HTTPRouteSpec{
+			Hostnames: []gatewayv1.Hostname{"*.example.com"},
+			Rules: []gatewayv1.HTTPRouteRule{
+				{
+					BackendRefs: []gatewayv1.HTTPBackendRef{
+						{BackendRef: gatewayv1.BackendRef{BackendObjectReference: gatewayv1.BackendObjectReference{Name: "aergia-backend"}}},
+					},
+				},
+			},
+		},
+	}
+	h := &Unidler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(route).Build(),
+	}
+	found, err := h.findHTTPRoute(context.Background(), "example-com-main", "", "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Name != "example" {
+		t.Errorf("findHTTPRoute() = %s, want example", found.Name)
+	}
+	h.restoreHTTPRoutes(context.Background(), "example-com-main", logr.Discard())
+	got := &gatewayv1.HTTPRoute{}
+	if err := h.Client.Get(context.Background(), types.NamespacedName{Namespace: "example-com-main", Name: "example"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Annotations["idling.amazee.io/unidle-rules"]; ok {
+		t.Errorf("unidle-rules annotation not removed")
+	}
+	if got.Labels["idling.amazee.io/idled"] != "false" {
+		t.Errorf("httproute not labelled as unidled")
+	}
+	if backend := got.Spec.Rules[0].BackendRefs[0]; backend.Name != "nginx" || *backend.Port != 8080 {
+		t.Errorf("httproute backend = %v, want nginx:8080", backend)
+	}
+}
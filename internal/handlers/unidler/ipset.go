@@ -0,0 +1,85 @@
+package unidler
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// IPSet is a set of ip prefixes used by the allow and block lists, single addresses are stored as a /32 or /128 prefix.
+type IPSet []netip.Prefix
+
+// ParseIPSet parses a list of ip addresses and CIDR ranges. Empty entries and comments are ignored, any invalid entries
+// are skipped and returned in the error so the valid entries can still be used.
+func ParseIPSet(entries []string) (IPSet, error) {
+	var set IPSet
+	var errs []error
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		set = append(set, prefix)
+	}
+	return set, errors.Join(errs...)
+}
+
+// ReadIPSetFromFile reads an ip allow or block list file into an IPSet.
+func ReadIPSetFromFile(path string) (IPSet, error) {
+	entries, err := ReadSliceFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIPSet(entries)
+}
+
+// Contains checks if the address is in any of the prefixes in the set.
+func (s IPSet) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid ip range %s: %v", entry, err)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			// an ipv4 mapped range is the same as the ipv4 range
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := parseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid ip address %s: %v", entry, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseAddr parses an ip address as it may appear in a request header, optionally with a port, and normalises it so
+// ipv4 mapped ipv6 addresses and ipv6 zones match the entries in an IPSet.
+func parseAddr(value string) (netip.Addr, error) {
+	value = strings.TrimSpace(value)
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		addrPort, perr := netip.ParseAddrPort(value)
+		if perr != nil {
+			return netip.Addr{}, err
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.Unmap().WithZone(""), nil
+}
@@ -0,0 +1,101 @@
+package unidler
+
+import (
+	"testing"
+)
+
+func mustParseIPSet(t *testing.T, entries []string) IPSet {
+	t.Helper()
+	set, err := ParseIPSet(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+func TestIPSet_Contains(t *testing.T) {
+	set, err := ReadIPSetFromFile("testdata/cidrips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{
+			name: "test1",
+			ip:   "10.10.4.20",
+			want: true,
+		},
+		{
+			name: "test2",
+			ip:   "10.11.0.1",
+			want: false,
+		},
+		{
+			name: "test3",
+			ip:   "2001:DB8:0:0::1",
+			want: true,
+		},
+		{
+			name: "test4",
+			ip:   "::ffff:66.249.64.1",
+			want: true,
+		},
+		{
+			name: "test5",
+			ip:   " 66.249.64.1:443",
+			want: true,
+		},
+		{
+			name: "test6",
+			ip:   "[2001:db8::5]:8080",
+			want: true,
+		},
+		{
+			name: "test7",
+			ip:   "not-an-ip",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkIPList(set, []string{tt.ip}, ""); got != tt.want {
+				t.Errorf("checkIPList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIPSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "test1",
+			entries: []string{"1.2.3.4", " 192.168.0.0/24 ", "", "# comment", "::ffff:10.0.0.0/104"},
+			want:    3,
+		},
+		{
+			name:    "test2",
+			entries: []string{"1.2.3.4", "1.2.3.400", "10.0.0.0/33"},
+			want:    1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseIPSet(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIPSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseIPSet() = %v, want %d prefixes", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package unidler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// AccessLists are the global allow and block lists used to restrict which requests can unidle an environment.
+type AccessLists struct {
+	AllowedUserAgents []string
+	BlockedUserAgents []string
+	AllowedIPs        IPSet
+	BlockedIPs        IPSet
+}
+
+// ReadAccessLists reads the allow and block lists from a directory, any missing files are treated as empty lists.
+// If any of the lists contain invalid entries an error is returned along with the lists containing the valid entries.
+func ReadAccessLists(dir string) (*AccessLists, error) {
+	var errs []error
+	readAgents := func(name string) []string {
+		agents, err := ReadSliceFromFile(filepath.Join(dir, name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			}
+			return nil
+		}
+		valid := []string{}
+		for _, agent := range agents {
+			if _, err := regexp.Compile(agent); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", name, err))
+				continue
+			}
+			valid = append(valid, agent)
+		}
+		return valid
+	}
+	readIPs := func(name string) IPSet {
+		ips, err := ReadIPSetFromFile(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+		return ips
+	}
+	lists := &AccessLists{
+		AllowedUserAgents: readAgents("allowedagents"),
+		BlockedUserAgents: readAgents("blockedagents"),
+		AllowedIPs:        readIPs("allowedips"),
+		BlockedIPs:        readIPs("blockedips"),
+	}
+	return lists, errors.Join(errs...)
+}
+
+// SetAccessLists replaces the global allow and block lists, requests that are already being handled will continue to
+// use the lists they started with.
+func (h *Unidler) SetAccessLists(lists *AccessLists) {
+	h.lists.Store(lists)
+}
+
+// accessLists returns the current allow and block lists, if none have been set the lists defined on the unidler are used.
+func (h *Unidler) accessLists() *AccessLists {
+	if lists := h.lists.Load(); lists != nil {
+		return lists
+	}
+	return &AccessLists{
+		AllowedUserAgents: h.AllowedUserAgents,
+		BlockedUserAgents: h.BlockedUserAgents,
+		AllowedIPs:        h.AllowedIPs,
+		BlockedIPs:        h.BlockedIPs,
+	}
+}
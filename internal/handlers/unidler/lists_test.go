@@ -0,0 +1,46 @@
+package unidler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadAccessLists(t *testing.T) {
+	lists, err := ReadAccessLists("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists.BlockedUserAgents) != 1 || len(lists.BlockedIPs) != 1 || len(lists.AllowedIPs) != 1 {
+		t.Errorf("ReadAccessLists() = %v, want one blocked agent, one blocked ip and one allowed ip", lists)
+	}
+	if lists.AllowedUserAgents != nil {
+		t.Errorf("ReadAccessLists() allowed agents = %v, want nil", lists.AllowedUserAgents)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "blockedagents"), []byte("bot(\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadAccessLists(dir); err == nil {
+		t.Errorf("ReadAccessLists() expected an error for an invalid agent regex")
+	}
+}
+
+func TestUnidler_SetAccessLists(t *testing.T) {
+	h := &Unidler{
+		BlockedIPs: mustParseIPSet(t, []string{"1.2.3.4"}),
+	}
+	if h.checkAccess(nil, nil, "", "1.2.3.4", nil) {
+		t.Errorf("checkAccess() = true, want the initial block list to be used")
+	}
+	h.SetAccessLists(&AccessLists{
+		BlockedIPs: mustParseIPSet(t, []string{"10.0.0.0/8"}),
+	})
+	if !h.checkAccess(nil, nil, "", "1.2.3.4", nil) {
+		t.Errorf("checkAccess() = false, want the replaced block list to be used")
+	}
+	if h.checkAccess(nil, nil, "", "10.1.2.3", nil) {
+		t.Errorf("checkAccess() = true, want the replaced block list to be used")
+	}
+}
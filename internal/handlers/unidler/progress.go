@@ -0,0 +1,184 @@
+package unidler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ProgressPath is the path the unidle page requests the progress of unidling from. It is requested through the
+	// idled ingress or httproute, so the path is unlikely to be used by an environment.
+	ProgressPath = "/.aergia/progress"
+
+	progressInterval = 2 * time.Second
+)
+
+/*
+Progress is the progress of unidling a namespace. It has the replicas and pods of each deployment, so it is only served
+to requests that pass the same checks as unidling requests.
+*/
+type Progress struct {
+	Namespace   string               `json:"namespace"`
+	Unidling    bool                 `json:"unidling"`
+	Ready       bool                 `json:"ready"`
+	Deployments []DeploymentProgress `json:"deployments"`
+}
+
+// DeploymentProgress is the progress of a deployment that is being unidled.
+type DeploymentProgress struct {
+	Name            string        `json:"name"`
+	DesiredReplicas int32         `json:"desiredReplicas"`
+	ReadyReplicas   int32         `json:"readyReplicas"`
+	Ready           bool          `json:"ready"`
+	Pods            []PodProgress `json:"pods"`
+}
+
+// PodProgress is the phase of a pod of a deployment, and the reason the first waiting container is waiting.
+type PodProgress struct {
+	Name          string          `json:"name"`
+	Phase         corev1.PodPhase `json:"phase"`
+	WaitingReason string          `json:"waitingReason,omitempty"`
+}
+
+// isProgressRequest checks if the request is for the progress of unidling. Requests through an idled ingress have the
+// original path in a header, requests through an idled httproute keep the original path.
+func isProgressRequest(r *http.Request) bool {
+	if uri := r.Header.Get(OriginalURI); uri != "" {
+		if u, err := url.Parse(uri); err == nil {
+			return u.Path == ProgressPath
+		}
+	}
+	return r.URL.Path == ProgressPath
+}
+
+// progressHandler responds with the progress of unidling the namespace, as json or as server-sent events until the
+// environment is ready, or for as long as the unidler waits for it.
+func (h *Unidler) progressHandler(w http.ResponseWriter, r *http.Request, opLog logr.Logger, namespace *corev1.Namespace) {
+	ns := namespace.Name
+	if r.Header.Get("Accept") != "text/event-stream" {
+		progress, err := h.getProgress(r.Context(), ns)
+		if err != nil {
+			opLog.Info(fmt.Sprintf("Unable to get the unidling progress of %s: %v", ns, err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(ContentType, "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(progress)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(ContentType, "text/event-stream")
+	// stop proxies from buffering the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	ctx, cancel := context.WithTimeout(r.Context(), pollTimeout(namespace, opLog))
+	defer cancel()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		progress, err := h.getProgress(ctx, ns)
+		if err != nil {
+			opLog.Info(fmt.Sprintf("Unable to get the unidling progress of %s: %v", ns, err))
+			return
+		}
+		data, _ := json.Marshal(progress)
+		if _, err := fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+		if progress.Ready {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+getProgress gets the replicas and pods of the deployments that aergia manages in the namespace, and checks if they are
+ready in the same way the unidler waits for them. The environment is ready once every deployment is ready, and the
+unidler has finished.
+*/
+func (h *Unidler) getProgress(ctx context.Context, ns string) (*Progress, error) {
+	_, unidling := h.Locks.Load(ns)
+	progress := &Progress{
+		Namespace:   ns,
+		Unidling:    unidling,
+		Deployments: []DeploymentProgress{},
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := h.Client.List(ctx, deployments, watchListOption(ns)); err != nil {
+		return nil, err
+	}
+	ready := len(deployments.Items) > 0 && !unidling
+	for _, deployment := range deployments.Items {
+		deploymentProgress := DeploymentProgress{
+			Name:          deployment.Name,
+			ReadyReplicas: deployment.Status.ReadyReplicas,
+			Pods:          []PodProgress{},
+		}
+		if deployment.Spec.Replicas != nil {
+			deploymentProgress.DesiredReplicas = *deployment.Spec.Replicas
+		}
+		// a deployment that is still scaled to zero hasn't been unidled yet
+		if deploymentProgress.DesiredReplicas > 0 {
+			deploymentReady, err := h.deploymentReady(ctx, deployment)
+			if err != nil {
+				return nil, err
+			}
+			deploymentProgress.Ready = deploymentReady
+		}
+		if !deploymentProgress.Ready {
+			ready = false
+		}
+		if deployment.Spec.Selector != nil {
+			selector, err := labels.ValidatedSelectorFromSet(deployment.Spec.Selector.MatchLabels)
+			if err != nil {
+				return nil, err
+			}
+			pods := &corev1.PodList{}
+			if err := h.Client.List(ctx, pods, ctrlClient.InNamespace(ns), ctrlClient.MatchingLabelsSelector{Selector: selector}); err != nil {
+				return nil, err
+			}
+			for _, pod := range pods.Items {
+				deploymentProgress.Pods = append(deploymentProgress.Pods, podProgress(pod))
+			}
+		}
+		progress.Deployments = append(progress.Deployments, deploymentProgress)
+	}
+	progress.Ready = ready
+	return progress, nil
+}
+
+func podProgress(pod corev1.Pod) PodProgress {
+	progress := PodProgress{
+		Name:  pod.Name,
+		Phase: pod.Status.Phase,
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil {
+			progress.WaitingReason = status.State.Waiting.Reason
+			break
+		}
+	}
+	return progress
+}
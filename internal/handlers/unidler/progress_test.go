@@ -0,0 +1,316 @@
+package unidler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestIsProgressRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		originalURI string
+		want        bool
+	}{
+		{
+			name:        "test1",
+			path:        "/",
+			originalURI: "/.aergia/progress",
+			want:        true,
+		},
+		{
+			name:        "test2",
+			path:        "/.aergia/progress",
+			originalURI: "/",
+			want:        false,
+		},
+		{
+			name: "test3",
+			path: "/.aergia/progress",
+			want: true,
+		},
+		{
+			name:        "test4",
+			path:        "/",
+			originalURI: "/.aergia/progress?page=1",
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.originalURI != "" {
+				r.Header.Set(OriginalURI, tt.originalURI)
+			}
+			if got := isProgressRequest(r); got != tt.want {
+				t.Errorf("isProgressRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnidler_getProgress(t *testing.T) {
+	tests := []struct {
+		name          string
+		readyReplicas int32
+		unidling      bool
+		wantReady     bool
+	}{
+		{
+			name:          "test1",
+			readyReplicas: 0,
+			wantReady:     false,
+		},
+		{
+			name:          "test2",
+			readyReplicas: 2,
+			wantReady:     true,
+		},
+		{
+			name:          "test3",
+			readyReplicas: 2,
+			unidling:      true,
+			wantReady:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newProgressUnidler(t, tt.readyReplicas)
+			if tt.unidling {
+				h.Locks.Store("example-com-main", "example-com-main")
+			}
+			progress, err := h.getProgress(context.Background(), "example-com-main")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if progress.Ready != tt.wantReady || progress.Unidling != tt.unidling {
+				t.Errorf("getProgress() ready = %v unidling = %v, want %v and %v", progress.Ready, progress.Unidling, tt.wantReady, tt.unidling)
+			}
+			// the deployment in the other namespace and the deployment without the watch label must not be included
+			want := []DeploymentProgress{
+				{
+					Name:            "nginx",
+					DesiredReplicas: 2,
+					ReadyReplicas:   tt.readyReplicas,
+					Ready:           tt.readyReplicas == 2,
+					Pods: []PodProgress{
+						{
+							Name:          "nginx-abc123",
+							Phase:         corev1.PodPending,
+							WaitingReason: "ContainerCreating",
+						},
+					},
+				},
+			}
+			if !reflect.DeepEqual(progress.Deployments, want) {
+				t.Errorf("getProgress() deployments = %+v, want %+v", progress.Deployments, want)
+			}
+		})
+	}
+}
+
+func TestUnidler_progressHandler(t *testing.T) {
+	h := newProgressUnidler(t, 2)
+	r := httptest.NewRequest(http.MethodGet, ProgressPath, nil)
+	r.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	h.progressHandler(w, r, logr.Discard(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}})
+	if got := w.Header().Get(ContentType); got != "text/event-stream" {
+		t.Errorf("progressHandler() content type = %s, want text/event-stream", got)
+	}
+	// the environment is ready, so only one event is sent
+	if got := strings.Count(w.Body.String(), "event: progress\n"); got != 1 {
+		t.Errorf("progressHandler() events = %d, want 1: %s", got, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"ready":true`) {
+		t.Errorf("progressHandler() body = %s, want ready", w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, ProgressPath, nil)
+	w = httptest.NewRecorder()
+	h.progressHandler(w, r, logr.Discard(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}})
+	if got := w.Header().Get(ContentType); got != "application/json" {
+		t.Errorf("progressHandler() content type = %s, want application/json", got)
+	}
+	if got := w.Body.String(); got != `{"namespace":"example-com-main","unidling":false,"ready":true,"deployments":[{"name":"nginx","desiredReplicas":2,"readyReplicas":2,"ready":true,"pods":[{"name":"nginx-abc123","phase":"Pending","waitingReason":"ContainerCreating"}]}]}`+"\n" {
+		t.Errorf("progressHandler() body = %s", got)
+	}
+}
+
+func TestUnidler_ingressHandlerProgress(t *testing.T) {
+	secret := "super-secret-string"
+	tests := []struct {
+		name             string
+		verifiedUnidling bool
+		verifier         string
+		query            bool
+		wantCode         int
+	}{
+		{
+			name:     "test1",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:             "test2",
+			verifiedUnidling: true,
+			wantCode:         http.StatusForbidden,
+		},
+		{
+			name:             "test3",
+			verifiedUnidling: true,
+			verifier:         hmacSigner("example-com-main", []byte(secret)),
+			wantCode:         http.StatusOK,
+		},
+		{
+			name:             "test4",
+			verifiedUnidling: true,
+			verifier:         hmacSigner("example-com-main", []byte(secret)),
+			query:            true,
+			wantCode:         http.StatusOK,
+		},
+		{
+			name:             "test5",
+			verifiedUnidling: true,
+			verifier:         hmacSigner("example-com-dev", []byte(secret)),
+			wantCode:         http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newProgressUnidler(t, 2)
+			h.VerifiedUnidling = tt.verifiedUnidling
+			h.VerifiedSecret = secret
+			originalURI := ProgressPath
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", "application/json")
+			r.Header.Set(Namespace, "example-com-main")
+			if tt.query {
+				originalURI += "?verifier=" + tt.verifier
+			} else if tt.verifier != "" {
+				r.Header.Set(AergiaVerifier, tt.verifier)
+			}
+			r.Header.Set(OriginalURI, originalURI)
+			w := httptest.NewRecorder()
+			h.ingressHandler("testdata/templates")(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("ingressHandler() code = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantCode == http.StatusOK && !strings.Contains(w.Body.String(), `"readyReplicas":2`) {
+				t.Errorf("ingressHandler() body = %s, want the progress", w.Body.String())
+			}
+		})
+	}
+}
+
+func newProgressUnidler(t *testing.T, readyReplicas int32) *Unidler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	deployment := func(namespace string, watchLabels map[string]string) *appsv1.Deployment {
+		replicas := int32(2)
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx",
+				Namespace: namespace,
+				Labels:    watchLabels,
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "nginx"},
+				},
+			},
+			Status: appsv1.DeploymentStatus{
+				ReadyReplicas: readyReplicas,
+			},
+		}
+	}
+	unwatched := deployment("example-com-main", nil)
+	unwatched.Name = "cli"
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"}},
+		deployment("example-com-main", map[string]string{"idling.amazee.io/watch": "true"}),
+		deployment("example-com-dev", map[string]string{"idling.amazee.io/watch": "true"}),
+		unwatched,
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nginx-abc123",
+				Namespace: "example-com-main",
+				Labels:    map[string]string{"app": "nginx"},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name: "nginx",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+						},
+					},
+				},
+			},
+		},
+	).Build()
+	return &Unidler{
+		Client: c,
+		Log:    logr.Discard(),
+	}
+}
+
+func TestRequestVerifier(t *testing.T) {
+	tests := []struct {
+		name        string
+		originalURI string
+		header      string
+		want        string
+	}{
+		{
+			name:        "test1",
+			originalURI: "/",
+			header:      "abc",
+			want:        "abc",
+		},
+		{
+			name:        "test2",
+			originalURI: ProgressPath + "?verifier=abc",
+			want:        "abc",
+		},
+		{
+			// the query parameter can't be used to unidle an environment
+			name:        "test3",
+			originalURI: "/?verifier=abc",
+			want:        "",
+		},
+		{
+			name:        "test4",
+			originalURI: "/page?verifier=abc",
+			header:      "def",
+			want:        "def",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/?verifier=abc", nil)
+			r.Header.Set(OriginalURI, tt.originalURI)
+			if tt.header != "" {
+				r.Header.Set(AergiaVerifier, tt.header)
+			}
+			if got := requestVerifier(r); got != tt.want {
+				t.Errorf("requestVerifier() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
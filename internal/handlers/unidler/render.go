@@ -0,0 +1,186 @@
+package unidler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-logr/logr"
+)
+
+// the states of a response from the unidler
+const (
+	StateUnidling = "unidling"
+	StateForced   = "forced"
+	StateBlocked  = "blocked"
+	StateError    = "error"
+	// StateThrottled is the state of an unidle request that was rate limited, or made during the cooldown
+	StateThrottled = "throttled"
+)
+
+// responseFormat is a format the unidler can respond with, template overrides for a format are read from files with
+// its extension.
+type responseFormat struct {
+	contentType string
+	extension   string
+}
+
+var responseFormats = []responseFormat{
+	{contentType: "text/html", extension: "html"},
+	{contentType: "application/json", extension: "json"},
+	{contentType: "text/plain", extension: "txt"},
+	{contentType: "application/xml", extension: "xml"},
+	{contentType: "text/xml", extension: "xml"},
+}
+
+// response is the body of a json or xml response.
+type response struct {
+	XMLName    xml.Name `json:"-" xml:"response"`
+	State      string   `json:"state" xml:"state"`
+	Code       int      `json:"code" xml:"code"`
+	Message    string   `json:"message" xml:"message"`
+	Namespace  string   `json:"namespace,omitempty" xml:"namespace,omitempty"`
+	RetryAfter int      `json:"retryAfter,omitempty" xml:"retryAfter,omitempty"`
+	Verifier   string   `json:"verifier,omitempty" xml:"verifier,omitempty"`
+	RequestID  string   `json:"requestId,omitempty" xml:"requestId,omitempty"`
+}
+
+// negotiateFormat returns the first supported format in the X-Format header, which contains the Accept header of the
+// original request, or the Accept header if it is a direct request. If no formats are supported, html is used.
+func negotiateFormat(r *http.Request) responseFormat {
+	accept := r.Header.Get(FormatHeader)
+	if accept == "" {
+		accept = r.Header.Get("Accept")
+	}
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, _, _ := strings.Cut(value, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		for _, format := range responseFormats {
+			if format.contentType == mediaType {
+				return format
+			}
+		}
+	}
+	return responseFormats[0]
+}
+
+// templateName returns the name of the template file used for a state.
+func templateName(state string) string {
+	switch state {
+	case StateUnidling:
+		return "unidle"
+	case StateForced:
+		return "forced"
+	case StateThrottled:
+		return "throttled"
+	}
+	return "error"
+}
+
+// statusCode returns the status code of the response, which is the code of the original request.
+func (h *Unidler) statusCode(r *http.Request) int {
+	code, err := strconv.Atoi(r.Header.Get(CodeHeader))
+	if err != nil {
+		return h.DefaultHTTPResponseCode
+	}
+	return code
+}
+
+// render writes the response in the requested format. Html responses always use the templates in the path, the other
+// formats use a template in the path if one exists, otherwise a built in response is used.
+func (h *Unidler) render(w http.ResponseWriter, r *http.Request, opLog logr.Logger, format responseFormat, path string, data pageData) {
+	w.Header().Set(ContentType, format.contentType)
+	code := h.statusCode(r)
+	switch data.State {
+	case StateUnidling:
+		w.Header().Set("Retry-After", strconv.Itoa(data.RetryAfter))
+	case StateThrottled:
+		// throttled responses use their own status code, rather than the code of the original request
+		w.Header().Set("Retry-After", strconv.Itoa(data.RetryAfter))
+		code, _ = strconv.Atoi(data.ErrorCode)
+	}
+	w.WriteHeader(code)
+	file := fmt.Sprintf("%v/%s.%s", path, templateName(data.State), format.extension)
+	if _, err := os.Stat(file); err != nil && data.State == StateThrottled {
+		// custom templates from before the throttled state was added don't have a template for it
+		file = fmt.Sprintf("%v/%s.%s", path, templateName(StateError), format.extension)
+	}
+	if h.Debug {
+		opLog.Info(fmt.Sprintf("Serving custom error response for code %v and format %v from file %v", data.ErrorCode, format.contentType, file))
+	}
+	if format.extension == "html" {
+		tmpl := template.Must(template.ParseFiles(file))
+		_ = tmpl.ExecuteTemplate(w, "base", data)
+		return
+	}
+	if _, err := os.Stat(file); err == nil {
+		tmpl, err := template.ParseFiles(file)
+		if err == nil {
+			_ = tmpl.ExecuteTemplate(w, "base", data)
+			return
+		}
+		opLog.Info(fmt.Sprintf("Unable to parse template %s, using the default response: %v", file, err))
+	}
+	_ = writeResponse(w, format, data.response())
+}
+
+func (p pageData) response() response {
+	code, _ := strconv.Atoi(p.ErrorCode)
+	resp := response{
+		State:      p.State,
+		Code:       code,
+		Message:    p.ErrorMessage,
+		Namespace:  p.Namespace,
+		RetryAfter: p.RetryAfter,
+		Verifier:   p.Verifier,
+		RequestID:  p.RequestID,
+	}
+	switch p.State {
+	case StateUnidling:
+		resp.Message = "The environment is being unidled"
+		return resp
+	case StateForced:
+		resp.Message = "The environment has been force scaled, trigger a new deployment to restore it"
+	case StateThrottled:
+		return resp
+	}
+	// only an environment that is unidling can be retried
+	resp.RetryAfter = 0
+	return resp
+}
+
+func writeResponse(w io.Writer, format responseFormat, resp response) error {
+	switch format.extension {
+	case "json":
+		return json.NewEncoder(w).Encode(resp)
+	case "xml":
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(resp)
+	}
+	lines := []string{
+		fmt.Sprintf("%d %s", resp.Code, resp.Message),
+		fmt.Sprintf("State: %s", resp.State),
+	}
+	if resp.Namespace != "" {
+		lines = append(lines, fmt.Sprintf("Namespace: %s", resp.Namespace))
+	}
+	if resp.RetryAfter != 0 {
+		lines = append(lines, fmt.Sprintf("Retry-After: %d", resp.RetryAfter))
+	}
+	if resp.Verifier != "" {
+		lines = append(lines, fmt.Sprintf("Verifier: %s", resp.Verifier))
+	}
+	if resp.RequestID != "" {
+		lines = append(lines, fmt.Sprintf("Request-ID: %s", resp.RequestID))
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
@@ -0,0 +1,160 @@
+package unidler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		accept string
+		want   string
+	}{
+		{
+			name:   "test1",
+			format: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			want:   "text/html",
+		},
+		{
+			name:   "test2",
+			format: "application/json",
+			want:   "application/json",
+		},
+		{
+			name:   "test3",
+			format: "application/vnd.api+json, text/plain;q=0.5",
+			want:   "text/plain",
+		},
+		{
+			name:   "test4",
+			accept: "application/xml",
+			want:   "application/xml",
+		},
+		{
+			name:   "test5",
+			format: "image/webp,*/*",
+			want:   "text/html",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.format != "" {
+				r.Header.Set(FormatHeader, tt.format)
+			}
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if got := negotiateFormat(r); got.contentType != tt.want {
+				t.Errorf("negotiateFormat() = %v, want %v", got.contentType, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnidler_render(t *testing.T) {
+	tests := []struct {
+		name            string
+		format          string
+		data            pageData
+		wantCode        int
+		wantContentType string
+		wantRetryAfter  string
+		wantBody        string
+	}{
+		{
+			name:            "test1",
+			format:          "application/json",
+			data:            pageData{ErrorCode: "503", Namespace: "example-com-main", State: StateUnidling, RetryAfter: 30, Verifier: "abc", RequestID: "123"},
+			wantContentType: "application/json",
+			wantRetryAfter:  "30",
+			wantBody:        `{"state":"unidling","code":503,"message":"The environment is being unidled","namespace":"example-com-main","retryAfter":30,"verifier":"abc","requestId":"123"}` + "\n",
+		},
+		{
+			name:            "test2",
+			format:          "application/json",
+			data:            pageData{ErrorCode: "403", ErrorMessage: "Forbidden", Namespace: "example-com-main", State: StateBlocked, RetryAfter: 30},
+			wantContentType: "application/json",
+			wantBody:        `{"state":"blocked","code":403,"message":"Forbidden","namespace":"example-com-main"}` + "\n",
+		},
+		{
+			name:            "test3",
+			format:          "application/xml",
+			data:            pageData{ErrorCode: "503", Namespace: "example-com-main", State: StateForced},
+			wantContentType: "application/xml",
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<response><state>forced</state><code>503</code><message>The environment has been force scaled, trigger a new deployment to restore it</message><namespace>example-com-main</namespace></response>`,
+		},
+		{
+			name:            "test4",
+			format:          "text/plain",
+			data:            pageData{ErrorCode: "503", Namespace: "example-com-main", State: StateUnidling, RetryAfter: 30},
+			wantContentType: "text/plain",
+			wantRetryAfter:  "30",
+			wantBody:        "503 The environment is being unidled\nState: unidling\nNamespace: example-com-main\nRetry-After: 30\n",
+		},
+		{
+			name:            "test5",
+			format:          "text/plain",
+			data:            pageData{ErrorCode: "404", Namespace: "example-com-main", State: StateError},
+			wantContentType: "text/plain",
+			wantBody:        "404 error example-com-main",
+		},
+		{
+			name:            "test6",
+			format:          "text/html",
+			data:            pageData{ErrorCode: "503", Namespace: "example-com-main", State: StateUnidling, RetryAfter: 30},
+			wantContentType: "text/html",
+			wantRetryAfter:  "30",
+			wantBody:        "<html><body>example-com-main unidling</body></html>",
+		},
+		{
+			name:            "test7",
+			format:          "application/json",
+			data:            pageData{ErrorCode: "429", ErrorMessage: "Too many requests", Namespace: "example-com-main", State: StateThrottled, RetryAfter: 12},
+			wantCode:        http.StatusTooManyRequests,
+			wantContentType: "application/json",
+			wantRetryAfter:  "12",
+			wantBody:        `{"state":"throttled","code":429,"message":"Too many requests","namespace":"example-com-main","retryAfter":12}` + "\n",
+		},
+		{
+			name:            "test8",
+			format:          "text/plain",
+			data:            pageData{ErrorCode: "503", Namespace: "example-com-main", State: StateThrottled, RetryAfter: 60},
+			wantContentType: "text/plain",
+			wantRetryAfter:  "60",
+			wantBody:        "503 throttled example-com-main",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Unidler{DefaultHTTPResponseCode: 404}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(FormatHeader, tt.format)
+			r.Header.Set(CodeHeader, "503")
+			w := httptest.NewRecorder()
+			h.render(w, r, logr.Discard(), negotiateFormat(r), "testdata/templates", tt.data)
+			wantCode := tt.wantCode
+			if wantCode == 0 {
+				wantCode = http.StatusServiceUnavailable
+			}
+			if w.Code != wantCode {
+				t.Errorf("render() code = %d, want %d", w.Code, wantCode)
+			}
+			if got := w.Header().Get(ContentType); got != tt.wantContentType {
+				t.Errorf("render() content type = %s, want %s", got, tt.wantContentType)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("render() retry after = %s, want %s", got, tt.wantRetryAfter)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("render() body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
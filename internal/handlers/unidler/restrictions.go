@@ -17,38 +17,48 @@ func checkAgents(blockedAgents []string, requestUserAgent string) bool {
 	return false
 }
 
-func checkIPList(allowList []string, xForwardedFor []string, trueClientIP string) bool {
+func checkIPList(allowList IPSet, xForwardedFor []string, trueClientIP string) bool {
 	var clientIPs []string
 	if trueClientIP != "" {
 		clientIPs = append(clientIPs, trueClientIP)
 	} else {
 		clientIPs = xForwardedFor
 	}
-	for _, ua := range allowList {
-		for _, ip := range clientIPs {
-			if ua == ip {
-				return true
-			}
+	for _, ip := range clientIPs {
+		addr, err := parseAddr(ip)
+		if err != nil {
+			continue
+		}
+		if allowList.Contains(addr) {
+			return true
 		}
 	}
 	return false
 }
 
+// annotationIPSet returns the IPSet for an annotation value, invalid entries in an annotation are ignored.
+func annotationIPSet(value string) IPSet {
+	set, _ := ParseIPSet(strings.Split(value, ","))
+	return set
+}
+
 func (h *Unidler) checkAccess(nsannotations map[string]string, annotations map[string]string, userAgent, trueClientIP string, xForwardedFor []string) bool {
+	// use the same lists for all the checks, even if they are reloaded during the request
+	lists := h.accessLists()
 	// deal with ip allow/blocks first
-	blockedIP := checkIPAnnotations("idling.amazee.io/ip-block-list", trueClientIP, xForwardedFor, h.BlockedIPs, nsannotations, annotations)
+	blockedIP := checkIPAnnotations("idling.amazee.io/ip-block-list", trueClientIP, xForwardedFor, lists.BlockedIPs, nsannotations, annotations)
 	if blockedIP {
 		return false
 	}
-	allowedIP := checkIPAnnotations("idling.amazee.io/ip-allow-list", trueClientIP, xForwardedFor, h.AllowedIPs, nsannotations, annotations)
+	allowedIP := checkIPAnnotations("idling.amazee.io/ip-allow-list", trueClientIP, xForwardedFor, lists.AllowedIPs, nsannotations, annotations)
 	if allowedIP {
 		return true
 	}
-	blockedAgent := checkAgentAnnotations("idling.amazee.io/blocked-agents", userAgent, h.BlockedUserAgents, nsannotations, annotations)
+	blockedAgent := checkAgentAnnotations("idling.amazee.io/blocked-agents", userAgent, lists.BlockedUserAgents, nsannotations, annotations)
 	if blockedAgent {
 		return false
 	}
-	allowedAgent := checkAgentAnnotations("idling.amazee.io/allowed-agents", userAgent, h.AllowedUserAgents, nsannotations, annotations)
+	allowedAgent := checkAgentAnnotations("idling.amazee.io/allowed-agents", userAgent, lists.AllowedUserAgents, nsannotations, annotations)
 	if allowedAgent {
 		return true
 	}
@@ -71,16 +81,16 @@ func checkAgentAnnotations(annotation, ua string, g []string, ns, i map[string]s
 	return allow
 }
 
-func checkIPAnnotations(annotation, tcip string, xff, g []string, ns, i map[string]string) bool {
+func checkIPAnnotations(annotation, tcip string, xff []string, g IPSet, ns, i map[string]string) bool {
 	allow := false
 	if alist, ok := i[annotation]; ok {
 		// there is an allow list, we want to deny any requests now unless they are the trueclientip
 		// or xforwardedfor if trueclientip is not defined
-		allow = checkIPList(strings.Split(alist, ","), xff, tcip)
+		allow = checkIPList(annotationIPSet(alist), xff, tcip)
 	} else {
 		// check for namespace annoation
 		if alist, ok := ns[annotation]; ok {
-			allow = checkIPList(strings.Split(alist, ","), xff, tcip)
+			allow = checkIPList(annotationIPSet(alist), xff, tcip)
 		} else if g != nil {
 			allow = checkIPList(g, xff, tcip)
 		}
@@ -101,7 +101,11 @@ func Test_checkIPList(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := checkIPList(tt.args.allowList, tt.args.xForwardedFor, tt.args.trueClientIP); got != tt.want {
+			allowList, err := ParseIPSet(tt.args.allowList)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := checkIPList(allowList, tt.args.xForwardedFor, tt.args.trueClientIP); got != tt.want {
 				t.Errorf("checkIPList() = %v, want %v", got, tt.want)
 			}
 		})
@@ -388,8 +392,8 @@ func TestUnidler_checkAccess(t *testing.T) {
 			h := &Unidler{
 				AllowedUserAgents: tt.fields.AllowedUserAgents,
 				BlockedUserAgents: tt.fields.BlockedUserAgents,
-				AllowedIPs:        tt.fields.AllowedIPs,
-				BlockedIPs:        tt.fields.BlockedIPs,
+				AllowedIPs:        mustParseIPSet(t, tt.fields.AllowedIPs),
+				BlockedIPs:        mustParseIPSet(t, tt.fields.BlockedIPs),
 			}
 			if got := h.checkAccess(tt.args.nsannotations, tt.args.annotations, tt.args.userAgent, tt.args.trueClientIP, tt.args.xForwardedFor); got != tt.want {
 				t.Errorf("Unidler.checkAccess() = %v, want %v", got, tt.want)
@@ -0,0 +1,144 @@
+package unidler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// UnidleCooldownAnnotation sets how long after a namespace is idled that requests can unidle it again.
+const UnidleCooldownAnnotation = "idling.amazee.io/unidle-cooldown"
+
+// the reasons an unidle request is throttled
+const (
+	ThrottledNamespace = "namespace"
+	ThrottledClient    = "client"
+	ThrottledCooldown  = "cooldown"
+)
+
+// how often buckets that have refilled are removed
+const limiterPruneInterval = time.Minute
+
+/*
+Limiter is a token bucket for each key, such as a namespace or a client ip. A bucket that has refilled is removed, as
+it is the same as a new bucket, so the number of buckets is bounded by the keys seen in the time it takes to refill.
+*/
+type Limiter struct {
+	limit   rate.Limit
+	burst   int
+	lock    sync.Mutex
+	buckets map[string]*rate.Limiter
+	pruned  time.Time
+}
+
+// NewLimiter returns a limiter that allows perMinute requests a minute for each key, with bursts of up to burst
+// requests. If perMinute is 0 or less there is no limit, and nil is returned.
+func NewLimiter(perMinute, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		limit:   rate.Every(time.Minute / time.Duration(perMinute)),
+		burst:   burst,
+		buckets: map[string]*rate.Limiter{},
+	}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty, it returns false and how long until there
+// is a token. A nil limiter allows everything.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if now.Sub(l.pruned) > limiterPruneInterval {
+		for k, bucket := range l.buckets {
+			if bucket.TokensAt(now) >= float64(l.burst) {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[key] = bucket
+	}
+	reservation := bucket.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+/*
+clientIP returns the ip the requests of a client are limited by. The client can set the `True-Client-IP` header and the
+start of `X-Forwarded-For` to anything, so the last `X-Forwarded-For` address is used, which is the one appended by the
+ingress controller, or the remote address of the request if there isn't one.
+*/
+func clientIP(xForwardedFor []string, remoteAddr string) string {
+	if len(xForwardedFor) > 0 {
+		if last := strings.TrimSpace(xForwardedFor[len(xForwardedFor)-1]); last != "" {
+			return last
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+/*
+throttle checks that a request is allowed to unidle the namespace. The namespace must be past its cooldown, and the
+client and namespace must have tokens in their buckets. If the request is throttled, the reason and how long until it
+can be retried are returned.
+*/
+func (h *Unidler) throttle(ctx context.Context, namespace *corev1.Namespace, client string, opLog logr.Logger) (string, time.Duration) {
+	ctx, span := tracing.Start(ctx, "Throttle")
+	defer span.End()
+	now := time.Now()
+	if remaining := cooldownRemaining(namespace, now, opLog); remaining > 0 {
+		return ThrottledCooldown, remaining
+	}
+	// check the client first, so that a throttled client doesn't use up the tokens of the namespace
+	if ok, delay := h.ClientLimiter.Allow(client, now); !ok {
+		return ThrottledClient, delay
+	}
+	if ok, delay := h.NamespaceLimiter.Allow(namespace.Name, now); !ok {
+		return ThrottledNamespace, delay
+	}
+	return "", 0
+}
+
+// cooldownRemaining returns how long until the cooldown of the namespace has passed, since the idler annotated the
+// namespace with when it was idled.
+func cooldownRemaining(namespace *corev1.Namespace, now time.Time, opLog logr.Logger) time.Duration {
+	value, ok := namespace.Annotations[UnidleCooldownAnnotation]
+	if !ok {
+		return 0
+	}
+	cooldown, err := time.ParseDuration(value)
+	if err != nil || cooldown <= 0 {
+		opLog.Info(fmt.Sprintf("Invalid %s annotation %s, ignoring it", UnidleCooldownAnnotation, value))
+		return 0
+	}
+	idledAt, err := time.Parse(time.RFC3339, namespace.Annotations["idling.amazee.io/idled-at"])
+	if err != nil {
+		// it hasn't been idled by this version of the idler, or it has been unidled since
+		return 0
+	}
+	return idledAt.Add(cooldown).Sub(now)
+}
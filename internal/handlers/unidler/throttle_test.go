@@ -0,0 +1,180 @@
+package unidler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	type request struct {
+		key       string
+		after     time.Duration
+		wantAllow bool
+		wantDelay time.Duration
+	}
+	tests := []struct {
+		name      string
+		perMinute int
+		burst     int
+		requests  []request
+	}{
+		{
+			name:      "test1",
+			perMinute: 0,
+			burst:     1,
+			requests: []request{
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: true},
+			},
+		},
+		{
+			name:      "test2",
+			perMinute: 2,
+			burst:     2,
+			requests: []request{
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: false, wantDelay: 30 * time.Second},
+				{key: "b", wantAllow: true},
+				{key: "a", after: 30 * time.Second, wantAllow: true},
+				{key: "a", after: 30 * time.Second, wantAllow: false, wantDelay: 30 * time.Second},
+			},
+		},
+		{
+			name:      "test3",
+			perMinute: 1,
+			burst:     0,
+			requests: []request{
+				{key: "a", wantAllow: true},
+				{key: "a", after: 15 * time.Second, wantAllow: false, wantDelay: 45 * time.Second},
+				{key: "a", after: 2 * time.Minute, wantAllow: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.perMinute, tt.burst)
+			for i, r := range tt.requests {
+				allow, delay := l.Allow(r.key, start.Add(r.after))
+				if allow != r.wantAllow || delay != r.wantDelay {
+					t.Errorf("request %d Allow() = %t, %s, want %t, %s", i, allow, delay, r.wantAllow, r.wantDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name          string
+		xForwardedFor []string
+		remoteAddr    string
+		want          string
+	}{
+		{
+			name:          "test1",
+			xForwardedFor: []string{"5.6.7.8"},
+			remoteAddr:    "10.0.0.1:1234",
+			want:          "5.6.7.8",
+		},
+		{
+			// the client can set the start of the header, only the address the ingress controller appended is used
+			name:          "test2",
+			xForwardedFor: []string{"5.6.7.8", " 10.0.0.2"},
+			remoteAddr:    "10.0.0.1:1234",
+			want:          "10.0.0.2",
+		},
+		{
+			name:          "test3",
+			xForwardedFor: []string{""},
+			remoteAddr:    "10.0.0.1:1234",
+			want:          "10.0.0.1",
+		},
+		{
+			name:       "test4",
+			remoteAddr: "[2001:db8::1]:1234",
+			want:       "2001:db8::1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientIP(tt.xForwardedFor, tt.remoteAddr); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnidler_throttle(t *testing.T) {
+	idledAt := time.Now().Add(-5 * time.Minute).Format(time.RFC3339)
+	tests := []struct {
+		name             string
+		cooldown         string
+		namespaceLimiter *Limiter
+		clientLimiter    *Limiter
+		wantReason       []string
+	}{
+		{
+			name:       "test1",
+			wantReason: []string{"", "", ""},
+		},
+		{
+			name:       "test2",
+			cooldown:   "10m",
+			wantReason: []string{ThrottledCooldown, ThrottledCooldown},
+		},
+		{
+			name:       "test3",
+			cooldown:   "2m",
+			wantReason: []string{"", ""},
+		},
+		{
+			name:       "test4",
+			cooldown:   "soon",
+			wantReason: []string{""},
+		},
+		{
+			name:             "test5",
+			namespaceLimiter: NewLimiter(1, 2),
+			wantReason:       []string{"", "", ThrottledNamespace},
+		},
+		{
+			name:             "test6",
+			namespaceLimiter: NewLimiter(1, 2),
+			clientLimiter:    NewLimiter(1, 1),
+			wantReason:       []string{"", ThrottledClient, ThrottledClient},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "example-com-main"},
+			}
+			if tt.cooldown != "" {
+				namespace.Annotations = map[string]string{
+					UnidleCooldownAnnotation:    tt.cooldown,
+					"idling.amazee.io/idled-at": idledAt,
+				}
+			}
+			h := &Unidler{
+				NamespaceLimiter: tt.namespaceLimiter,
+				ClientLimiter:    tt.clientLimiter,
+			}
+			for i, want := range tt.wantReason {
+				reason, retryAfter := h.throttle(context.Background(), namespace, "1.2.3.4", logr.Discard())
+				if reason != want {
+					t.Errorf("request %d throttle() reason = %q, want %q", i, reason, want)
+				}
+				if (reason == "") != (retryAfter == 0) {
+					t.Errorf("request %d throttle() retry after = %s for reason %q", i, retryAfter, reason)
+				}
+			}
+		})
+	}
+}
@@ -7,21 +7,30 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	"github.com/uselagoon/aergia-controller/internal/handlers/notifier"
+	"github.com/uselagoon/aergia-controller/internal/handlers/recorder"
+	"github.com/uselagoon/aergia-controller/internal/handlers/tracing"
+	"github.com/uselagoon/aergia-controller/internal/handlers/waves"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/events"
 	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=list;get;watch;patch;update
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=list;get;watch;patch;update
+// +kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=list;get;watch;patch
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=list;get;watch
 // +kubebuilder:rbac:groups=*,resources=ingresses,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=*,resources=ingress/status,verbs=get;update;patch
@@ -31,7 +40,15 @@ const (
 	defaultPollTimeout  = 90 * time.Second
 )
 
+// the outcomes recorded for each unidle
+const (
+	UnidleReady    = "ready"
+	UnidleTimedOut = "timed-out"
+	UnidleFailed   = "failed"
+)
+
 // Unidler is the client structure for http handlers.
+// The allow and block lists are only used until they are replaced with SetAccessLists.
 type Unidler struct {
 	Client                  ctrlClient.Client
 	Log                     logr.Logger
@@ -43,9 +60,15 @@ type Unidler struct {
 	Locks                   sync.Map
 	AllowedUserAgents       []string
 	BlockedUserAgents       []string
-	AllowedIPs              []string
-	BlockedIPs              []string
+	AllowedIPs              IPSet
+	BlockedIPs              IPSet
 	DefaultHTTPResponseCode int
+	HTTPRoutes              bool
+	NamespaceLimiter        *Limiter
+	ClientLimiter           *Limiter
+	Recorder                events.EventRecorder
+	Notifier                *notifier.Notifier
+	lists                   atomic.Pointer[AccessLists]
 }
 
 type pageData struct {
@@ -62,6 +85,8 @@ type pageData struct {
 	ErrorCode       string
 	ErrorMessage    string
 	Verifier        string
+	State           string
+	RetryAfter      int
 }
 
 const (
@@ -81,6 +106,8 @@ const (
 	ServiceName = "X-Service-Name"
 	// ServicePort name of the header that contains the matched Service port in the Ingress
 	ServicePort = "X-Service-Port"
+	// HTTPRouteName name of the header that contains the HTTPRoute that was idled
+	HTTPRouteName = "X-HTTPRoute-Name"
 	// RequestID is a unique ID that identifies the request - same as for backend service
 	RequestID = "X-Request-ID"
 	// AergiaHeader name of the header that contains if this has been served by aergia
@@ -128,85 +155,254 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s\n", favicon)
 }
 
-func (h *Unidler) Unidle(ctx context.Context, namespace *corev1.Namespace, opLog logr.Logger) {
+// Unidle scales the workloads of the namespace back up and restores its ingresses, it returns the outcome, which is
+// UnidleReady once the environment is ready.
+func (h *Unidler) Unidle(ctx context.Context, namespace *corev1.Namespace, opLog logr.Logger) string {
 	defer h.Locks.Delete(namespace.Name)
-	// get the deployments in the namespace if they have the `watch=true` label
+	start := time.Now()
+	outcome := UnidleReady
+	ctx, span := tracing.Start(ctx, "Unidle", tracing.AttributeNamespace.String(namespace.Name))
+	defer func() {
+		recordUnidle(namespace.Name, outcome, time.Since(start))
+		h.recordOutcome(ctx, namespace, outcome)
+		span.SetAttributes(tracing.AttributeOutcome.String(outcome))
+		span.End()
+	}()
+	// the statefulsets and every wave of deployments share one deadline to be ready, so that a namespace that never
+	// becomes ready is only waited on for the unidle timeout, however many workloads it has
+	timeout := pollTimeout(namespace, opLog)
+	deadline := time.Now().Add(timeout)
+	listOption := watchListOption(namespace.Name)
+	// statefulsets are unidled first, as the web deployments will usually depend on them
+	statefulSets := &appsv1.StatefulSetList{}
+	if err := h.Client.List(ctx, statefulSets, listOption); err != nil {
+		opLog.Info(fmt.Sprintf("Unable to get any statefulsets - %s", namespace.Name))
+	} else {
+		for _, sts := range statefulSets.Items {
+			lv, lok := sts.Labels["idling.amazee.io/idled"]
+			if lok && lv == "true" && sts.Spec.Replicas != nil && *sts.Spec.Replicas == 0 {
+				newReplicas, mergePatch := unidlePatch(sts.Annotations)
+				scaleStatefulSet := sts.DeepCopy()
+				patchCtx, patchSpan := tracing.Start(ctx, "PatchStatefulSet", tracing.AttributeStatefulSet.String(sts.Name))
+				err := h.Client.Patch(patchCtx, scaleStatefulSet, ctrlClient.RawPatch(types.MergePatchType, mergePatch))
+				tracing.End(patchSpan, err)
+				if err != nil {
+					opLog.Info(fmt.Sprintf("Error scaling statefulset %s - %s", sts.Name, namespace.Name))
+					outcome = UnidleFailed
+				} else {
+					opLog.Info(fmt.Sprintf("StatefulSet %s scaled to %d - %s", sts.Name, newReplicas, namespace.Name))
+					recorder.Object(h.Recorder, &sts, corev1.EventTypeNormal, recorder.ReasonUnidled, recorder.ActionUnidle,
+						"Scaled to %d replicas", newReplicas)
+				}
+			}
+		}
+		waitCtx, cancel := context.WithDeadline(ctx, deadline)
+		for _, sts := range statefulSets.Items {
+			opLog.Info(fmt.Sprintf("Waiting for statefulset %s to be ready - %s", sts.Name, namespace.Name))
+			stsCtx, waitSpan := tracing.Start(waitCtx, "WaitForStatefulSet", tracing.AttributeStatefulSet.String(sts.Name))
+			err := wait.PollUntilContextCancel(stsCtx, defaultPollDuration, true, h.hasReadyStatefulSet(stsCtx, namespace.Name, sts.Name))
+			tracing.End(waitSpan, err)
+			if err != nil {
+				opLog.Error(err, "error waiting for statefulsets")
+				outcome = worstOutcome(outcome, UnidleTimedOut)
+			}
+		}
+		cancel()
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := h.Client.List(ctx, deployments, listOption); err != nil {
+		opLog.Info(fmt.Sprintf("Unable to get any deployments - %s", namespace.Name))
+		outcome = UnidleFailed
+		return outcome
+	}
+	// deployments are unidled in waves, each wave is only started once the previous one is ready
+	unidleWaves, err := waves.Order(deployments.Items)
+	if err != nil {
+		opLog.Info(fmt.Sprintf("Unable to order deployments into waves, unidling them together - %s: %v", namespace.Name, err))
+		recorder.Namespace(h.Recorder, namespace, corev1.EventTypeWarning, recorder.ReasonUnidleWave, recorder.ActionUnidle,
+			"Unable to order deployments into waves, unidling them together: %v", err)
+		unidleWaves = [][]appsv1.Deployment{deployments.Items}
+	}
+	for i, wave := range unidleWaves {
+		if len(unidleWaves) > 1 {
+			opLog.Info(fmt.Sprintf("Unidling wave %d of %d: %s - %s", i+1, len(unidleWaves), strings.Join(waves.Names(wave), ", "), namespace.Name))
+		}
+		waveCtx, waveSpan := tracing.Start(ctx, "UnidleWave", tracing.AttributeWave.Int(i+1))
+		if !h.unidleWave(waveCtx, namespace, wave, opLog) {
+			outcome = UnidleFailed
+		}
+		ready := h.waitForWave(waveCtx, namespace, wave, deadline, opLog)
+		if !ready {
+			outcome = worstOutcome(outcome, UnidleTimedOut)
+		}
+		waveSpan.End()
+		if len(unidleWaves) > 1 {
+			if ready {
+				recorder.Namespace(h.Recorder, namespace, corev1.EventTypeNormal, recorder.ReasonUnidleWave, recorder.ActionUnidle,
+					"Wave %d of %d ready: %s", i+1, len(unidleWaves), strings.Join(waves.Names(wave), ", "))
+			} else {
+				recorder.Namespace(h.Recorder, namespace, corev1.EventTypeWarning, recorder.ReasonUnidleWave, recorder.ActionUnidle,
+					"Wave %d of %d not ready within the unidle timeout of %s, continuing: %s", i+1, len(unidleWaves), timeout, strings.Join(waves.Names(wave), ", "))
+			}
+		}
+	}
+	// resume any cronjobs now that the environment is running again
+	h.unsuspendCronJobs(ctx, namespace.Name, listOption, opLog)
+	// remove the 503 code from any ingress objects that have it in this namespace
+	h.removeCodeFromIngress(ctx, namespace.Name, opLog)
+	// restore the rules of any httproutes that were redirected to aergia
+	if h.HTTPRoutes {
+		h.restoreHTTPRoutes(ctx, namespace.Name, opLog)
+	}
+	// label the namespace to indicate it is unidled, and when, so that it isn't idled again before the minimum awake time
+	namespaceCopy := namespace.DeepCopy()
+	mergePatch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				"idling.amazee.io/idled": "false",
+			},
+			"annotations": map[string]interface{}{
+				"idling.amazee.io/unidled-at":   time.Now().UTC().Format(time.RFC3339),
+				"idling.amazee.io/idled-at":     nil,
+				"idling.amazee.io/force-scaled": nil,
+			},
+		},
+	})
+	patchCtx, patchSpan := tracing.Start(ctx, "PatchNamespace")
+	err = h.Client.Patch(patchCtx, namespaceCopy, ctrlClient.RawPatch(types.MergePatchType, mergePatch))
+	tracing.End(patchSpan, err)
+	if err != nil {
+		opLog.Info(fmt.Sprintf("Error patching namespace %s", namespace.Name))
+		outcome = UnidleFailed
+	}
+	return outcome
+}
+
+/*
+recordOutcome records an event on the namespace for the outcome of an unidle. The unidle is only counted, and the unidled
+webhook only sent, once the environment is ready, otherwise a warning event is recorded with the outcome.
+*/
+func (h *Unidler) recordOutcome(ctx context.Context, namespace *corev1.Namespace, outcome string) {
+	switch outcome {
+	case UnidleReady:
+		metrics.UnidleEvents.Inc()
+		recorder.Namespace(h.Recorder, namespace, corev1.EventTypeNormal, recorder.ReasonUnidled, recorder.ActionUnidle,
+			"Environment unidled")
+		h.Notifier.Publish(ctx, notifier.EventUnidled, namespace, "Environment unidled")
+	case UnidleTimedOut:
+		recorder.Namespace(h.Recorder, namespace, corev1.EventTypeWarning, recorder.ReasonUnidlingFailed, recorder.ActionUnidle,
+			"Environment unidle %s, it was not ready within the unidle timeout", outcome)
+	default:
+		recorder.Namespace(h.Recorder, namespace, corev1.EventTypeWarning, recorder.ReasonUnidlingFailed, recorder.ActionUnidle,
+			"Environment unidle %s, it could not be scaled or the namespace could not be patched", outcome)
+	}
+}
+
+// watchListOption lists the deployments, statefulsets and cronjobs in the namespace that have the `watch=true` label.
+func watchListOption(ns string) *ctrlClient.ListOptions {
 	labelRequirements1, _ := labels.NewRequirement("idling.amazee.io/watch", selection.Equals, []string{"true"})
-	listOption := (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
-		ctrlClient.InNamespace(namespace.Name),
+	return (&ctrlClient.ListOptions{}).ApplyOptions([]ctrlClient.ListOption{
+		ctrlClient.InNamespace(ns),
 		ctrlClient.MatchingLabelsSelector{
 			Selector: labels.NewSelector().Add(*labelRequirements1),
 		},
 	})
-	deployments := &appsv1.DeploymentList{}
-	if err := h.Client.List(ctx, deployments, listOption); err != nil {
-		opLog.Info(fmt.Sprintf("Unable to get any deployments - %s", namespace.Name))
-		return
-	}
-	for _, deploy := range deployments.Items {
+}
+
+// unidleWave scales the idled deployments in a wave back up, it returns false if any of them couldn't be scaled.
+func (h *Unidler) unidleWave(ctx context.Context, namespace *corev1.Namespace, wave []appsv1.Deployment, opLog logr.Logger) bool {
+	scaled := true
+	for _, deploy := range wave {
 		// if the idled annotation is true
 		lv, lok := deploy.Labels["idling.amazee.io/idled"]
 		if lok && lv == "true" {
 			opLog.Info(fmt.Sprintf("Deployment %s - Replicas %v - %s", deploy.Name, *deploy.Spec.Replicas, namespace.Name))
 			if *deploy.Spec.Replicas == 0 {
-				// default to scaling to 1 replica
-				newReplicas := 1
-				if value, ok := deploy.Annotations["idling.amazee.io/unidle-replicas"]; ok {
-					// but if the value of the annotation is greater than 0, use what is in the annotation instead
-					unidleReplicas, err := strconv.Atoi(value)
-					if err == nil {
-						if unidleReplicas > 0 {
-							newReplicas = unidleReplicas
-						}
-					}
-				}
-				mergePatch, _ := json.Marshal(map[string]interface{}{
-					"spec": map[string]interface{}{
-						"replicas": newReplicas,
-					},
-					"metadata": map[string]interface{}{
-						"labels": map[string]interface{}{
-							"idling.amazee.io/idled":        "false",
-							"idling.amazee.io/force-idled":  nil,
-							"idling.amazee.io/force-scaled": nil,
-						},
-						"annotations": map[string]interface{}{
-							"idling.amazee.io/idled-at": nil,
-						},
-					},
-				})
+				newReplicas, mergePatch := unidlePatch(deploy.Annotations)
 				scaleDepConf := deploy.DeepCopy()
-				if err := h.Client.Patch(ctx, scaleDepConf, ctrlClient.RawPatch(types.MergePatchType, mergePatch)); err != nil {
+				patchCtx, patchSpan := tracing.Start(ctx, "PatchDeployment", tracing.AttributeDeployment.String(deploy.Name))
+				err := h.Client.Patch(patchCtx, scaleDepConf, ctrlClient.RawPatch(types.MergePatchType, mergePatch))
+				tracing.End(patchSpan, err)
+				if err != nil {
 					// log it but try and scale the rest of the deployments anyway (some idled is better than none?)
 					opLog.Info(fmt.Sprintf("Error scaling deployment %s - %s", deploy.Name, namespace.Name))
+					scaled = false
 				} else {
 					opLog.Info(fmt.Sprintf("Deployment %s scaled to %d - %s", deploy.Name, newReplicas, namespace.Name))
+					recorder.Object(h.Recorder, &deploy, corev1.EventTypeNormal, recorder.ReasonUnidled, recorder.ActionUnidle,
+						"Scaled to %d replicas", newReplicas)
 				}
 			}
 		}
 	}
-	// now wait for the pods of these deployments to be ready
-	// this could still result in 503 for users until the resulting services/endpoints are active and receiving traffic
-	for _, deploy := range deployments.Items {
-		opLog.Info(fmt.Sprintf("Waiting for %s to be running - %s", deploy.Name, namespace.Name))
-		err := wait.PollUntilContextTimeout(ctx, defaultPollDuration, defaultPollTimeout, true, h.hasRunningPod(ctx, namespace.Name, deploy.Name))
+	return scaled
+}
+
+// waitForWave waits for the deployments in a wave to be ready and their services to have ready endpoints,
+// it returns false if any of them weren't ready before the deadline of the unidle.
+func (h *Unidler) waitForWave(ctx context.Context, namespace *corev1.Namespace, wave []appsv1.Deployment, deadline time.Time, opLog logr.Logger) bool {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	ready := true
+	for _, deploy := range wave {
+		opLog.Info(fmt.Sprintf("Waiting for %s to be ready - %s", deploy.Name, namespace.Name))
+		waitCtx, waitSpan := tracing.Start(ctx, "WaitForDeployment", tracing.AttributeDeployment.String(deploy.Name))
+		err := wait.PollUntilContextCancel(waitCtx, defaultPollDuration, true, h.hasReadyDeployment(waitCtx, namespace.Name, deploy.Name))
+		tracing.End(waitSpan, err)
 		if err != nil {
 			opLog.Error(err, "error waiting for deployments")
+			ready = false
+		}
+	}
+	return ready
+}
+
+// recordUnidle records how long an unidle took until the environment was ready, and if it timed out or failed.
+func recordUnidle(namespace, outcome string, duration time.Duration) {
+	metrics.UnidleDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+	switch outcome {
+	case UnidleTimedOut:
+		metrics.UnidleFailures.WithLabelValues(namespace, "timeout").Inc()
+	case UnidleFailed:
+		metrics.UnidleFailures.WithLabelValues(namespace, "error").Inc()
+	}
+}
+
+// worstOutcome returns the outcome of an unidle, a failure takes precedence over a timeout.
+func worstOutcome(current, outcome string) string {
+	if current == UnidleFailed {
+		return current
+	}
+	return outcome
+}
+
+// unidlePatch returns the number of replicas to restore from the unidle-replicas annotation, and the merge patch to do it.
+func unidlePatch(annotations map[string]string) (int, []byte) {
+	// default to scaling to 1 replica
+	newReplicas := 1
+	if value, ok := annotations["idling.amazee.io/unidle-replicas"]; ok {
+		// but if the value of the annotation is greater than 0, use what is in the annotation instead
+		unidleReplicas, err := strconv.Atoi(value)
+		if err == nil {
+			if unidleReplicas > 0 {
+				newReplicas = unidleReplicas
+			}
 		}
 	}
-	// remove the 503 code from any ingress objects that have it in this namespace
-	h.removeCodeFromIngress(ctx, namespace.Name, opLog)
-	// label the namespace to indicate it is idled
-	namespaceCopy := namespace.DeepCopy()
 	mergePatch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": newReplicas,
+		},
 		"metadata": map[string]interface{}{
-			"labels": map[string]string{
-				"idling.amazee.io/idled": "false",
+			"labels": map[string]interface{}{
+				"idling.amazee.io/idled":        "false",
+				"idling.amazee.io/force-idled":  nil,
+				"idling.amazee.io/force-scaled": nil,
+			},
+			"annotations": map[string]interface{}{
+				"idling.amazee.io/idled-at": nil,
 			},
 		},
 	})
-	metrics.UnidleEvents.Inc()
-	if err := h.Client.Patch(ctx, namespaceCopy, ctrlClient.RawPatch(types.MergePatchType, mergePatch)); err != nil {
-		opLog.Info(fmt.Sprintf("Error patching namespace %s", namespace.Name))
-	}
+	return newReplicas, mergePatch
 }
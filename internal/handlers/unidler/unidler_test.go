@@ -0,0 +1,143 @@
+package unidler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/uselagoon/aergia-controller/internal/handlers/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	ctrlClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUnidler_Unidle(t *testing.T) {
+	tests := []struct {
+		name         string
+		namespace    string
+		exists       bool
+		wantFailures float64
+		wantEvent    string
+	}{
+		{
+			name:      "test1",
+			namespace: "example-com-main",
+			exists:    true,
+			wantEvent: "Normal Unidled Environment unidled",
+		},
+		{
+			name:         "test2",
+			namespace:    "example-com-dev",
+			wantFailures: 1,
+			wantEvent:    "Warning UnidlingFailed Environment unidle failed, it could not be scaled or the namespace could not be patched",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			namespace := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   tt.namespace,
+					Labels: map[string]string{"idling.amazee.io/idled": "true"},
+				},
+			}
+			objects := []ctrlClient.Object{}
+			if tt.exists {
+				objects = append(objects, namespace.DeepCopy())
+			}
+			eventRecorder := events.NewFakeRecorder(10)
+			h := &Unidler{
+				Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+				Log:      logr.Discard(),
+				Recorder: eventRecorder,
+			}
+			start := time.Now().Add(-time.Second)
+			h.Unidle(context.Background(), namespace, logr.Discard())
+			// an unidle that couldn't patch the namespace is recorded as a failure
+			if got := testutil.ToFloat64(metrics.UnidleFailures.WithLabelValues(tt.namespace, "error")); got != tt.wantFailures {
+				t.Errorf("unidle failures = %v, want %v", got, tt.wantFailures)
+			}
+			// only a ready environment is recorded as unidled
+			select {
+			case event := <-eventRecorder.Events:
+				if event != tt.wantEvent {
+					t.Errorf("event = %q, want %q", event, tt.wantEvent)
+				}
+			default:
+				t.Errorf("event not recorded, want %q", tt.wantEvent)
+			}
+			if !tt.exists {
+				return
+			}
+			got := &corev1.Namespace{}
+			if err := h.Client.Get(context.Background(), types.NamespacedName{Name: namespace.Name}, got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Labels["idling.amazee.io/idled"] != "false" {
+				t.Errorf("namespace not labelled as unidled")
+			}
+			unidledAt, err := time.Parse(time.RFC3339, got.Annotations["idling.amazee.io/unidled-at"])
+			if err != nil {
+				t.Fatalf("unidled-at annotation = %q: %v", got.Annotations["idling.amazee.io/unidled-at"], err)
+			}
+			if unidledAt.Before(start.Truncate(time.Second)) || unidledAt.After(time.Now()) {
+				t.Errorf("unidled-at annotation = %s, want the time it was unidled", unidledAt)
+			}
+		})
+	}
+}
+
+func TestUnidler_UnidleDeadline(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example-com-timeout",
+			Labels:      map[string]string{"idling.amazee.io/idled": "true"},
+			Annotations: map[string]string{UnidleTimeoutAnnotation: "2s"},
+		},
+	}
+	objects := []ctrlClient.Object{namespace.DeepCopy()}
+	idledLabels := map[string]string{"idling.amazee.io/watch": "true", "idling.amazee.io/idled": "true"}
+	// the fake client never makes the workloads ready, so each of them waits until the deadline
+	for _, name := range []string{"mariadb", "redis"} {
+		replicas := int32(0)
+		objects = append(objects, &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace.Name, Labels: idledLabels},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		})
+	}
+	for _, name := range []string{"nginx", "php"} {
+		replicas := int32(0)
+		objects = append(objects, &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace.Name, Labels: idledLabels},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		})
+	}
+	h := &Unidler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:    logr.Discard(),
+	}
+	start := time.Now()
+	h.Unidle(context.Background(), namespace, logr.Discard())
+	// each workload waiting for the whole timeout would take 8 seconds
+	if took := time.Since(start); took > 4*time.Second {
+		t.Errorf("Unidle() took %s, want the workloads to share the 2s timeout", took)
+	}
+	if got := testutil.ToFloat64(metrics.UnidleFailures.WithLabelValues(namespace.Name, "timeout")); got != 1 {
+		t.Errorf("unidle timeouts = %v, want 1", got)
+	}
+}
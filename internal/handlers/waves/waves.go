@@ -0,0 +1,107 @@
+package waves
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const (
+	// WaveAnnotation is the annotation on a deployment with the wave it is unidled in, lower waves are unidled first.
+	WaveAnnotation = "idling.amazee.io/unidle-wave"
+	// AfterAnnotation is the annotation on a deployment with a comma separated list of deployments that must be
+	// ready before it is unidled.
+	AfterAnnotation = "idling.amazee.io/unidle-after"
+)
+
+// Order groups deployments into the waves they should be unidled in, a deployment is placed in the wave from its
+// wave annotation, or the wave after the last of the deployments it is unidled after if that is later.
+// Dependencies on deployments that aren't in the list are ignored. Deployments within a wave are sorted by name.
+// If a wave annotation is invalid or the dependencies contain a cycle, an error is returned.
+func Order(deployments []appsv1.Deployment) ([][]appsv1.Deployment, error) {
+	index := map[string]int{}
+	for i, deployment := range deployments {
+		index[deployment.Name] = i
+	}
+	ranks := make([]int, len(deployments))
+	// 0 unvisited, 1 visiting, 2 done
+	state := make([]int, len(deployments))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		switch state[i] {
+		case 2:
+			return nil
+		case 1:
+			return fmt.Errorf("dependency cycle %s", strings.Join(append(path, deployments[i].Name), " -> "))
+		}
+		state[i] = 1
+		path = append(path, deployments[i].Name)
+		rank := 0
+		if value, ok := deployments[i].Annotations[WaveAnnotation]; ok {
+			wave, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || wave < 0 {
+				return fmt.Errorf("invalid %s annotation %q on deployment %s", WaveAnnotation, value, deployments[i].Name)
+			}
+			rank = wave
+		}
+		for _, name := range after(deployments[i]) {
+			j, ok := index[name]
+			if !ok {
+				continue
+			}
+			if err := visit(j, path); err != nil {
+				return err
+			}
+			if ranks[j]+1 > rank {
+				rank = ranks[j] + 1
+			}
+		}
+		ranks[i] = rank
+		state[i] = 2
+		return nil
+	}
+	for i := range deployments {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	grouped := map[int][]appsv1.Deployment{}
+	keys := []int{}
+	for i, deployment := range deployments {
+		if _, ok := grouped[ranks[i]]; !ok {
+			keys = append(keys, ranks[i])
+		}
+		grouped[ranks[i]] = append(grouped[ranks[i]], deployment)
+	}
+	sort.Ints(keys)
+	waves := [][]appsv1.Deployment{}
+	for _, key := range keys {
+		wave := grouped[key]
+		sort.Slice(wave, func(a, b int) bool { return wave[a].Name < wave[b].Name })
+		waves = append(waves, wave)
+	}
+	return waves, nil
+}
+
+// after returns the names of the deployments a deployment is unidled after.
+func after(deployment appsv1.Deployment) []string {
+	names := []string{}
+	for _, name := range strings.Split(deployment.Annotations[AfterAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Names returns the names of the deployments in a wave.
+func Names(wave []appsv1.Deployment) []string {
+	names := make([]string, 0, len(wave))
+	for _, deployment := range wave {
+		names = append(names, deployment.Name)
+	}
+	return names
+}
@@ -0,0 +1,91 @@
+package waves
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func deployment(name string, annotations map[string]string) appsv1.Deployment {
+	return appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		deployments []appsv1.Deployment
+		want        [][]string
+		wantErr     bool
+	}{
+		{
+			name: "test1",
+			deployments: []appsv1.Deployment{
+				deployment("php", nil),
+				deployment("nginx", nil),
+			},
+			want: [][]string{{"nginx", "php"}},
+		},
+		{
+			name: "test2",
+			deployments: []appsv1.Deployment{
+				deployment("nginx", map[string]string{AfterAnnotation: "php"}),
+				deployment("php", map[string]string{AfterAnnotation: "mariadb, redis"}),
+				deployment("redis", nil),
+				deployment("mariadb", nil),
+			},
+			want: [][]string{{"mariadb", "redis"}, {"php"}, {"nginx"}},
+		},
+		{
+			name: "test3",
+			deployments: []appsv1.Deployment{
+				deployment("nginx", map[string]string{WaveAnnotation: "2"}),
+				deployment("php", map[string]string{WaveAnnotation: "1"}),
+				deployment("mariadb", nil),
+				deployment("cli", map[string]string{AfterAnnotation: "mariadb"}),
+			},
+			want: [][]string{{"mariadb"}, {"cli", "php"}, {"nginx"}},
+		},
+		{
+			name: "test4",
+			deployments: []appsv1.Deployment{
+				deployment("nginx", map[string]string{WaveAnnotation: "0", AfterAnnotation: "solr"}),
+			},
+			want: [][]string{{"nginx"}},
+		},
+		{
+			name: "test5",
+			deployments: []appsv1.Deployment{
+				deployment("nginx", map[string]string{AfterAnnotation: "php"}),
+				deployment("php", map[string]string{AfterAnnotation: "nginx"}),
+			},
+			wantErr: true,
+		},
+		{
+			name: "test6",
+			deployments: []appsv1.Deployment{
+				deployment("nginx", map[string]string{WaveAnnotation: "first"}),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waves, err := Order(tt.deployments)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Order() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := [][]string{}
+			for _, wave := range waves {
+				got = append(got, Names(wave))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Order() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}